@@ -0,0 +1,66 @@
+package cachestore
+
+import (
+	"context"
+	"reflect"
+
+	"google.golang.org/appengine/datastore"
+)
+
+type allOrNothingKey struct{}
+
+// AllOrNothing returns a copy of c for which Get and GetMulti leave dst unchanged unless they load every entity. They
+// load the entities into copies of dst's elements instead, and only copy them into dst if they succeed, so on error
+// dst is as it was before the call, even for the keys that were loaded. The copies are shallow: like datastore, Get
+// and GetMulti may still append to the slices in dst's structs.
+func AllOrNothing(c context.Context) context.Context {
+	return context.WithValue(c, allOrNothingKey{}, true)
+}
+
+// isAllOrNothing returns whether c was returned by AllOrNothing.
+func isAllOrNothing(c context.Context) bool {
+	allOrNothing, _ := c.Value(allOrNothingKey{}).(bool)
+	return allOrNothing
+}
+
+// getAllOrNothing implements getEntities for an AllOrNothing context c, loading into a copy of dst which is only
+// copied into dst if loading succeeds.
+func (s *Cachestore) getAllOrNothing(c context.Context, key []*datastore.Key, dst interface{}, sources []Source) error {
+	v := reflect.ValueOf(dst)
+	multiArgType, _ := checkMultiArg(v)
+	tmp := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+	reflect.Copy(tmp, v)
+	if multiArgType == multiArgTypeStructPtr || multiArgType == multiArgTypeInterface {
+		for i := 0; i < tmp.Len(); i++ {
+			if e := pointee(tmp.Index(i)); e.IsValid() {
+				p := reflect.New(e.Type())
+				p.Elem().Set(e)
+				tmp.Index(i).Set(p)
+			}
+		}
+	}
+	err := s.getEntities(context.WithValue(c, allOrNothingKey{}, false), key, tmp.Interface(), sources)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < v.Len(); i++ {
+		if e := pointee(v.Index(i)); e.IsValid() {
+			e.Set(pointee(tmp.Index(i)))
+		} else {
+			v.Index(i).Set(tmp.Index(i))
+		}
+	}
+	return nil
+}
+
+// pointee returns the value e, an element of an []*S or []I, points to, or the zero Value if it isn't a non-nil
+// pointer.
+func pointee(e reflect.Value) reflect.Value {
+	if e.Kind() == reflect.Interface {
+		e = e.Elem()
+	}
+	if e.Kind() != reflect.Ptr || e.IsNil() {
+		return reflect.Value{}
+	}
+	return e.Elem()
+}
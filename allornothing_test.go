@@ -0,0 +1,93 @@
+package cachestore
+
+import (
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestGetMultiPartlyLoadsDstOnError(t *testing.T) {
+	requireAetest(t)
+	src := Struct{I: 1}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewKey(c, "Struct", "absentPartly", 0, nil)}
+	// Put
+	k, err := Put(c, key[0], &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key[0] = k
+	// GetMulti
+	dst := []Struct{{I: 8}, {I: 9}}
+	err = GetMulti(c, key, dst)
+	if me, ok := err.(appengine.MultiError); !ok || me[0] != nil || me[1] != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", appengine.MultiError{nil, datastore.ErrNoSuchEntity}, err)
+	}
+	if dst[0] != src {
+		t.Fatalf("expected=%#v actual=%#v", src, dst[0])
+	}
+	// Delete
+	err = Delete(c, key[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAllOrNothing(t *testing.T) {
+	requireAetest(t)
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cache the first
+	err = Get(c, key[0], &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti with an absent key
+	absent := []*datastore.Key{key[0], key[1], datastore.NewKey(c, "Struct", "absentAllOrNothing", 0, nil)}
+	dst := []Struct{{I: 7}, {I: 8}, {I: 9}}
+	err = GetMulti(AllOrNothing(c), absent, dst)
+	if me, ok := err.(appengine.MultiError); !ok || me[0] != nil || me[1] != nil || me[2] != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", appengine.MultiError{nil, nil, datastore.ErrNoSuchEntity}, err)
+	}
+	if expected := []Struct{{I: 7}, {I: 8}, {I: 9}}; dst[0] != expected[0] || dst[1] != expected[1] || dst[2] != expected[2] {
+		t.Fatalf("expected=%#v actual=%#v", expected, dst)
+	}
+	ptrs := []*Struct{{I: 7}, {I: 8}, {I: 9}}
+	before := ptrs[0]
+	err = GetMulti(AllOrNothing(c), absent, ptrs)
+	if _, ok := err.(appengine.MultiError); !ok {
+		t.Fatalf("expected=%#v actual=%#v", appengine.MultiError{nil, nil, datastore.ErrNoSuchEntity}, err)
+	}
+	if ptrs[0] != before || ptrs[0].I != 7 || ptrs[1].I != 8 || ptrs[2].I != 9 {
+		t.Fatalf("expected=%#v actual=%#v", []int{7, 8, 9}, []int{ptrs[0].I, ptrs[1].I, ptrs[2].I})
+	}
+	// GetMulti
+	ptrs = []*Struct{{I: 7}, nil}
+	before = ptrs[0]
+	err = GetMulti(AllOrNothing(c), key, ptrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ptrs[0] != before || *ptrs[0] != src[0] || ptrs[1] == nil || *ptrs[1] != src[1] {
+		t.Fatalf("expected=%#v actual=%#v", src, ptrs)
+	}
+	// GetMulti into a mismatching PropertyLoadSaver
+	ifaces := []interface{}{&Struct{}, &PropertyLoadSaver{}}
+	err = GetMulti(AllOrNothing(c), key, ifaces)
+	if me, ok := err.(appengine.MultiError); !ok || me[0] != nil || me[1] == nil {
+		t.Fatalf("expected an error for the second key, actual=%#v", err)
+	}
+	if *ifaces[0].(*Struct) != (Struct{}) {
+		t.Fatalf("expected=%#v actual=%#v", Struct{}, ifaces[0])
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
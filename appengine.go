@@ -5,7 +5,7 @@ package cachestore
 import (
 	"reflect"
 
-	"appengine/datastore"
+	"google.golang.org/appengine/datastore"
 )
 
 const (
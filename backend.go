@@ -0,0 +1,131 @@
+package cachestore
+
+import (
+	"context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+// MemcacheBackend is the part of the memcache package cachestore uses, to cache entities somewhere other than App
+// Engine's memcache, like a self-managed memcache cluster, or across several with ShardedMemcache. Implementations
+// must fail like the memcache package does, returning ErrCacheMiss, ErrNotStored, ErrCASConflict and
+// appengine.MultiErrors of them, and must only swap the items their own Get and GetMulti returned.
+type MemcacheBackend interface {
+	Get(c context.Context, key string) (*memcache.Item, error)
+	GetMulti(c context.Context, key []string) (map[string]*memcache.Item, error)
+	Add(c context.Context, item *memcache.Item) error
+	AddMulti(c context.Context, item []*memcache.Item) error
+	SetMulti(c context.Context, item []*memcache.Item) error
+	CompareAndSwapMulti(c context.Context, item []*memcache.Item) error
+	DeleteMulti(c context.Context, key []string) error
+	Increment(c context.Context, key string, delta int64, initialValue uint64) (uint64, error)
+}
+
+// datastorer is the part of the datastore package cachestore uses.
+type datastorer interface {
+	GetMulti(c context.Context, key []*datastore.Key, dst interface{}) error
+	PutMulti(c context.Context, key []*datastore.Key, src interface{}) ([]*datastore.Key, error)
+	DeleteMulti(c context.Context, key []*datastore.Key) error
+	GetAll(c context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error)
+	Count(c context.Context, q *datastore.Query) (int, error)
+	RunInTransaction(c context.Context, f func(tc context.Context) error, opts *datastore.TransactionOptions) error
+}
+
+// The default backends cachestore calls, replaced by fakes in tests.
+var (
+	memcacheBackend  MemcacheBackend = appengineMemcache{}
+	datastoreBackend datastorer      = appengineDatastore{}
+)
+
+// backend returns the MemcacheBackend s caches entities in.
+func (s *Cachestore) backend() MemcacheBackend {
+	if s.Memcache != nil {
+		return s.Memcache
+	}
+	return memcacheBackend
+}
+
+// appengineMemcache implements MemcacheBackend with the memcache package.
+type appengineMemcache struct{}
+
+func (appengineMemcache) Get(c context.Context, key string) (*memcache.Item, error) {
+	return memcache.Get(memcacheContext(c), key)
+}
+
+func (appengineMemcache) GetMulti(c context.Context, key []string) (map[string]*memcache.Item, error) {
+	return memcache.GetMulti(memcacheContext(c), key)
+}
+
+func (appengineMemcache) Add(c context.Context, item *memcache.Item) error {
+	return memcache.Add(memcacheContext(c), item)
+}
+
+func (appengineMemcache) AddMulti(c context.Context, item []*memcache.Item) error {
+	return memcache.AddMulti(memcacheContext(c), item)
+}
+
+func (appengineMemcache) SetMulti(c context.Context, item []*memcache.Item) error {
+	return memcache.SetMulti(memcacheContext(c), item)
+}
+
+func (appengineMemcache) CompareAndSwapMulti(c context.Context, item []*memcache.Item) error {
+	return memcache.CompareAndSwapMulti(memcacheContext(c), item)
+}
+
+func (appengineMemcache) DeleteMulti(c context.Context, key []string) error {
+	return memcache.DeleteMulti(memcacheContext(c), key)
+}
+
+func (appengineMemcache) Increment(c context.Context, key string, delta int64, initialValue uint64) (uint64, error) {
+	return memcache.Increment(memcacheContext(c), key, delta, initialValue)
+}
+
+type memcacheNamespaceKey struct{}
+
+// withMemcacheNamespace returns a copy of c whose memcache calls use s's MemcacheNamespace.
+func (s *Cachestore) withMemcacheNamespace(c context.Context) context.Context {
+	if s.MemcacheNamespace == "" {
+		return c
+	}
+	return context.WithValue(c, memcacheNamespaceKey{}, s.MemcacheNamespace)
+}
+
+// memcacheContext returns c in the namespace set by withMemcacheNamespace, the default namespace if there's none.
+// Encoded datastore keys include their namespace, so caching entities in one namespace keeps namespaces apart while
+// giving each entity the same memcache key whatever the namespace of the context reading or writing it.
+func memcacheContext(c context.Context) context.Context {
+	namespace, _ := c.Value(memcacheNamespaceKey{}).(string)
+	if nc, err := appengine.Namespace(c, namespace); err == nil {
+		return nc
+	}
+	return c
+}
+
+// appengineDatastore implements datastorer with the datastore package.
+type appengineDatastore struct{}
+
+func (appengineDatastore) GetMulti(c context.Context, key []*datastore.Key, dst interface{}) error {
+	return datastore.GetMulti(c, key, dst)
+}
+
+func (appengineDatastore) PutMulti(c context.Context, key []*datastore.Key, src interface{}) ([]*datastore.Key, error) {
+	return datastore.PutMulti(c, key, src)
+}
+
+func (appengineDatastore) DeleteMulti(c context.Context, key []*datastore.Key) error {
+	return datastore.DeleteMulti(c, key)
+}
+
+func (appengineDatastore) GetAll(c context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error) {
+	return q.GetAll(c, dst)
+}
+
+func (appengineDatastore) Count(c context.Context, q *datastore.Query) (int, error) {
+	return q.Count(c)
+}
+
+func (appengineDatastore) RunInTransaction(c context.Context, f func(tc context.Context) error, opts *datastore.TransactionOptions) error {
+	return datastore.RunInTransaction(c, f, opts)
+}
@@ -0,0 +1,295 @@
+package cachestore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+// fakeMemcache is an in-memory MemcacheBackend. Items don't expire.
+type fakeMemcache struct {
+	mu      sync.Mutex
+	items   map[string]fakeMemcacheItem
+	version uint64
+	issued  map[*memcache.Item]uint64 // versions of the items returned by Get and GetMulti, for CompareAndSwapMulti
+}
+
+type fakeMemcacheItem struct {
+	value   []byte
+	flags   uint32
+	version uint64
+}
+
+func newFakeMemcache() *fakeMemcache {
+	return &fakeMemcache{items: map[string]fakeMemcacheItem{}, issued: map[*memcache.Item]uint64{}}
+}
+
+func (m *fakeMemcache) Get(c context.Context, key string) (*memcache.Item, error) {
+	items, _ := m.GetMulti(c, []string{key})
+	if item, ok := items[key]; ok {
+		return item, nil
+	}
+	return nil, memcache.ErrCacheMiss
+}
+
+func (m *fakeMemcache) GetMulti(c context.Context, key []string) (map[string]*memcache.Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	items := make(map[string]*memcache.Item)
+	for _, k := range key {
+		if i, ok := m.items[k]; ok {
+			item := &memcache.Item{Key: k, Value: append([]byte(nil), i.value...), Flags: i.flags}
+			m.issued[item] = i.version
+			items[k] = item
+		}
+	}
+	return items, nil
+}
+
+func (m *fakeMemcache) Add(c context.Context, item *memcache.Item) error {
+	return single(m.AddMulti(c, []*memcache.Item{item}))
+}
+
+func (m *fakeMemcache) AddMulti(c context.Context, item []*memcache.Item) error {
+	return m.set(item, func(item *memcache.Item, ok bool, current fakeMemcacheItem) error {
+		if ok {
+			return memcache.ErrNotStored
+		}
+		return nil
+	})
+}
+
+func (m *fakeMemcache) SetMulti(c context.Context, item []*memcache.Item) error {
+	return m.set(item, func(*memcache.Item, bool, fakeMemcacheItem) error { return nil })
+}
+
+func (m *fakeMemcache) CompareAndSwapMulti(c context.Context, item []*memcache.Item) error {
+	return m.set(item, func(item *memcache.Item, ok bool, current fakeMemcacheItem) error {
+		if !ok {
+			return memcache.ErrNotStored
+		}
+		if version, issued := m.issued[item]; !issued || version != current.version {
+			return memcache.ErrCASConflict
+		}
+		return nil
+	})
+}
+
+// set writes the items check returns no error for.
+func (m *fakeMemcache) set(item []*memcache.Item, check func(*memcache.Item, bool, fakeMemcacheItem) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	multiErr, any := make(appengine.MultiError, len(item)), false
+	for i, it := range item {
+		current, ok := m.items[it.Key]
+		if err := check(it, ok, current); err != nil {
+			multiErr[i], any = err, true
+			continue
+		}
+		m.version++
+		m.items[it.Key] = fakeMemcacheItem{value: append([]byte(nil), it.Value...), flags: it.Flags, version: m.version}
+	}
+	if any {
+		return multiErr
+	}
+	return nil
+}
+
+func (m *fakeMemcache) DeleteMulti(c context.Context, key []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	multiErr, any := make(appengine.MultiError, len(key)), false
+	for i, k := range key {
+		if _, ok := m.items[k]; !ok {
+			multiErr[i], any = memcache.ErrCacheMiss, true
+		}
+		delete(m.items, k)
+	}
+	if any {
+		return multiErr
+	}
+	return nil
+}
+
+func (m *fakeMemcache) Increment(c context.Context, key string, delta int64, initialValue uint64) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value := initialValue
+	if i, ok := m.items[key]; ok {
+		var err error
+		if value, err = strconv.ParseUint(string(i.value), 10, 64); err != nil {
+			return 0, err
+		}
+	}
+	value += uint64(delta)
+	m.version++
+	m.items[key] = fakeMemcacheItem{value: []byte(strconv.FormatUint(value, 10)), version: m.version}
+	return value, nil
+}
+
+// fakeDatastore is an in-memory datastorer that stores entities encoded like cached items. It doesn't support
+// queries, and runs transactions without isolation. Like datastore, GetMulti rejects a batch with an incomplete key.
+type fakeDatastore struct {
+	mu       sync.Mutex
+	entities map[string][]byte
+	nextID   int64
+	reads    int
+}
+
+func newFakeDatastore() *fakeDatastore {
+	return &fakeDatastore{entities: map[string][]byte{}}
+}
+
+func (d *fakeDatastore) GetMulti(c context.Context, key []*datastore.Key, dst interface{}) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v := reflect.ValueOf(dst)
+	multiArgType, _ := checkMultiArg(v)
+	for _, k := range key {
+		if k.Incomplete() {
+			return datastore.ErrInvalidKey
+		}
+	}
+	multiErr, any := make(appengine.MultiError, len(key)), false
+	for i, k := range key {
+		d.reads++
+		b, ok := d.entities[k.Encode()]
+		if !ok {
+			multiErr[i], any = datastore.ErrNoSuchEntity, true
+		} else if err := defaultCachestore().decodeElem(v, i, multiArgType, k, b); err != nil {
+			multiErr[i], any = err, true
+		}
+	}
+	if any {
+		return multiErr
+	}
+	return nil
+}
+
+func (d *fakeDatastore) PutMulti(c context.Context, key []*datastore.Key, src interface{}) ([]*datastore.Key, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v := reflect.ValueOf(src)
+	multiArgType, _ := checkMultiArg(v)
+	putKey := make([]*datastore.Key, len(key))
+	for i, k := range key {
+		if k.Incomplete() {
+			d.nextID++
+			k = datastore.NewKey(c, k.Kind(), "", d.nextID, k.Parent())
+		}
+		b, err := defaultCachestore().encode(elem(v, i, multiArgType).Interface())
+		if err != nil {
+			return nil, err
+		}
+		d.entities[k.Encode()] = b
+		putKey[i] = k
+	}
+	return putKey, nil
+}
+
+func (d *fakeDatastore) DeleteMulti(c context.Context, key []*datastore.Key) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, k := range key {
+		delete(d.entities, k.Encode())
+	}
+	return nil
+}
+
+func (d *fakeDatastore) GetAll(c context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error) {
+	return nil, errors.New("fake datastore: queries aren't supported")
+}
+
+func (d *fakeDatastore) Count(c context.Context, q *datastore.Query) (int, error) {
+	return 0, errors.New("fake datastore: queries aren't supported")
+}
+
+func (d *fakeDatastore) RunInTransaction(c context.Context, f func(tc context.Context) error, opts *datastore.TransactionOptions) error {
+	return f(c)
+}
+
+func single(err error) error {
+	if me, ok := err.(appengine.MultiError); ok {
+		return me[0]
+	}
+	return err
+}
+
+// withFakeBackends runs f with cachestore using a fake memcache and datastore.
+func withFakeBackends(f func(m *fakeMemcache, d *fakeDatastore)) {
+	m, d := newFakeMemcache(), newFakeDatastore()
+	memcacheBackend, datastoreBackend = m, d
+	defer func() { memcacheBackend, datastoreBackend = appengineMemcache{}, appengineDatastore{} }()
+	f(m, d)
+}
+
+func TestWithFakeBackends(t *testing.T) {
+	withFakeBackends(func(m *fakeMemcache, d *fakeDatastore) {
+		c := context.Background()
+		src := Struct{I: 3}
+		// Put
+		key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), &src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Get from datastore, then from memcache
+		for i := 0; i < 2; i++ {
+			dst := Struct{}
+			err = Get(c, key, &dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(src, dst) {
+				t.Fatalf("expected=%#v actual=%#v", src, dst)
+			}
+			if d.reads != 1 {
+				t.Fatalf("expected=%#v actual=%#v", 1, d.reads)
+			}
+		}
+		// Delete
+		err = Delete(c, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Get(c, key, &Struct{})
+		if err != datastore.ErrNoSuchEntity {
+			t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+		}
+	})
+}
+
+func TestFlushWithFakeBackends(t *testing.T) {
+	withFakeBackends(func(m *fakeMemcache, d *fakeDatastore) {
+		c := context.Background()
+		key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+		// PutMulti
+		key, err := PutMulti(c, key, []Struct{{1}, {2}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// load memcache with GetMulti
+		err = GetMulti(c, key, make([]Struct, len(key)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Flush, then GetMulti from datastore
+		err = Flush(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = GetMulti(c, key, make([]Struct, len(key)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.reads != 2*len(key) {
+			t.Fatalf("expected=%#v actual=%#v", 2*len(key), d.reads)
+		}
+	})
+}
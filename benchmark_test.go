@@ -0,0 +1,85 @@
+package cachestore
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// benchmarkGetMulti benchmarks GetMulti of 100 entities with fake backends, of which a fraction hit is cached. If
+// loadAll is set it benchmarks reading every entity from datastore after reading memcache instead of only the ones
+// that missed. It reports the datastore reads per GetMulti.
+func benchmarkGetMulti(b *testing.B, hit float64, loadAll bool) {
+	withFakeBackends(func(m *fakeMemcache, d *fakeDatastore) {
+		c := context.Background()
+		s := New()
+		src := make([]Struct, 100)
+		key := make([]*datastore.Key, len(src))
+		for i := range key {
+			src[i] = Struct{I: i}
+			key[i] = datastore.NewIncompleteKey(c, "Struct", nil)
+		}
+		key, err := s.PutMulti(c, key, src)
+		if err != nil {
+			b.Fatal(err)
+		}
+		cached := int(hit * float64(len(key)))
+		dst := make([]Struct, len(key))
+		d.reads = 0
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			if err := s.uncache(key, c); err != nil {
+				b.Fatal(err)
+			}
+			if err := s.cache(key[:cached], src[:cached], nil, c); err != nil {
+				b.Fatal(err)
+			}
+			b.StartTimer()
+			if loadAll {
+				if _, _, err := s.getEntityItems(c, s.encodeKeys(key)); err != nil {
+					b.Fatal(err)
+				}
+				err = datastoreBackend.GetMulti(c, key, dst)
+			} else {
+				err = s.GetMulti(c, key, dst)
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(d.reads)/float64(b.N), "reads/op")
+	})
+}
+
+func BenchmarkGetMultiAllMiss(b *testing.B)             { benchmarkGetMulti(b, 0, false) }
+func BenchmarkGetMultiPartialHit50(b *testing.B)        { benchmarkGetMulti(b, 0.5, false) }
+func BenchmarkGetMultiPartialHit90(b *testing.B)        { benchmarkGetMulti(b, 0.9, false) }
+func BenchmarkGetMultiAllHit(b *testing.B)              { benchmarkGetMulti(b, 1, false) }
+func BenchmarkGetMultiLoadAllMiss(b *testing.B)         { benchmarkGetMulti(b, 0, true) }
+func BenchmarkGetMultiLoadAllPartialHit50(b *testing.B) { benchmarkGetMulti(b, 0.5, true) }
+func BenchmarkGetMultiLoadAllPartialHit90(b *testing.B) { benchmarkGetMulti(b, 0.9, true) }
+
+// BenchmarkGetHit benchmarks Get of a cached entity with fake backends.
+func BenchmarkGetHit(b *testing.B) {
+	withFakeBackends(func(m *fakeMemcache, d *fakeDatastore) {
+		c := context.Background()
+		s := New()
+		key, err := s.Put(c, datastore.NewIncompleteKey(c, "Struct", nil), &Struct{I: 1})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := s.Get(c, key, &Struct{}); err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := s.Get(c, key, &Struct{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
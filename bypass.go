@@ -0,0 +1,21 @@
+package cachestore
+
+import "context"
+
+type bypassKey struct{}
+
+// WithBypass returns a copy of c for which Get, GetMulti and their variants, GetStream, GetAll, CachedCount, Exists,
+// ExistsMulti, Put, PutMulti, Delete, DeleteMulti and DeleteIfExists go straight to datastore without reading or
+// writing memcache, as if cachestore wasn't there. Functions that only use memcache, like GetMemcacheOnly, Cached or
+// SetCacheOnly, still do. Unlike Strong, the entities read aren't cached. The entities written or deleted aren't
+// removed from memcache either, only from LocalCache and the request cache, so reads without WithBypass may return
+// them stale until they expire: use it for entities that aren't cached, or Refresh them afterwards.
+func WithBypass(c context.Context) context.Context {
+	return context.WithValue(c, bypassKey{}, true)
+}
+
+// isBypass returns whether c was returned by WithBypass.
+func isBypass(c context.Context) bool {
+	bypass, _ := c.Value(bypassKey{}).(bool)
+	return bypass
+}
@@ -0,0 +1,174 @@
+package cachestore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// bypassContext returns a WithBypass context that fails t if it's used to call memcache.
+func bypassContext(t *testing.T) context.Context {
+	return WithBypass(appengine.WithAPICallFunc(c, func(ctx context.Context, s, m string, in, out proto.Message) error {
+		if s == "memcache" {
+			t.Errorf("unexpected memcache call %s", m)
+			return errors.New("unexpected memcache call")
+		}
+		return appengine.APICall(ctx, s, m, in, out)
+	}))
+}
+
+func TestWithBypass(t *testing.T) {
+	requireAetest(t)
+	noMemcache := bypassContext(t)
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMulti
+	key, err := PutMulti(noMemcache, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti
+	before := Stats()
+	dst := make([]Struct, len(key))
+	err = GetMulti(noMemcache, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	if reads := Stats().DatastoreReads - before.DatastoreReads; reads != 2 {
+		t.Fatalf("expected=%#v actual=%#v", 2, reads)
+	}
+	// nothing was cached
+	err = GetMultiMemcacheOnly(c, key, make([]Struct, len(key)))
+	if _, ok := err.(appengine.MultiError); !ok {
+		t.Fatalf("expected an appengine.MultiError, actual=%#v", err)
+	}
+	// DeleteMulti
+	err = DeleteMulti(noMemcache, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = GetMulti(noMemcache, key, dst)
+	if me, ok := err.(appengine.MultiError); !ok || me[0] != datastore.ErrNoSuchEntity || me[1] != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", appengine.MultiError{datastore.ErrNoSuchEntity, datastore.ErrNoSuchEntity}, err)
+	}
+}
+
+func TestWithBypassWriteThrough(t *testing.T) {
+	requireAetest(t)
+	WriteThrough = true
+	defer func() { WriteThrough = false }()
+	noMemcache := bypassContext(t)
+	// Put
+	key, err := Put(noMemcache, datastore.NewIncompleteKey(c, "Struct", nil), &Struct{I: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Delete
+	err = Delete(noMemcache, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithBypassQueries(t *testing.T) {
+	requireAetest(t)
+	noMemcache := bypassContext(t)
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "BypassQuery", nil), datastore.NewIncompleteKey(c, "BypassQuery", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetAll
+	q := datastore.NewQuery("BypassQuery").Order("I")
+	var dst []Struct
+	actual, err := GetAll(noMemcache, q, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(key, actual) || !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// CachedCount
+	n, err := CachedCount(noMemcache, q, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(src) {
+		t.Fatalf("expected=%#v actual=%#v", len(src), n)
+	}
+	// nothing was cached
+	if _, ok := New().getQueryKeys(c, New().encodeQuery(c, q)); ok {
+		t.Fatal("expected the query not to be cached")
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithBypassReadsDatastoreForCachedEntities(t *testing.T) {
+	requireAetest(t)
+	noMemcache := bypassContext(t)
+	// Put
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), &Struct{I: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cache it, then update datastore without cachestore
+	err = Get(c, key, &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := &Struct{I: 2}
+	_, err = datastore.Put(c, key, updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetStream
+	results, err := GetStream(noMemcache, []*datastore.Key{key}, func() interface{} { return &Struct{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r := range results {
+		if r.Err != nil || r.Source != FromDatastore || !reflect.DeepEqual(updated, r.Entity) {
+			t.Fatalf("expected=%#v actual=%#v", Result{Key: key, Entity: updated, Source: FromDatastore}, r)
+		}
+	}
+	// ExistsMulti after deleting from datastore without cachestore
+	err = datastore.Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := ExistsMulti(noMemcache, []*datastore.Key{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists[0] {
+		t.Fatal("expected the deleted entity not to exist")
+	}
+	// DeleteIfExists
+	existed, err := DeleteIfExists(noMemcache, []*datastore.Key{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if existed[0] {
+		t.Fatal("expected the deleted entity not to have existed")
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
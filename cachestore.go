@@ -1,40 +1,282 @@
-// Cachestore automatically caches structs in memcache using gob and the structs' encoded datastore.Key.
+// Cachestore automatically caches structs in memcache using a Codec (gob by default) and the structs' encoded
+// datastore.Key.
 // Reads check memcache first, if they miss they read from datastore and write the results into memcache.
 // Writes write to both memcache and datastore. Cachestore will try to write to the datastore even if an
 // error occurs when writing to memcache.
 //
-// Types need to be registered with gob.Register(interface{}) for cachestore to be able to store them.
+// When using the Gob Codec, types need to be registered with Register or gob.Register(interface{}) for cachestore to
+// be able to store them.
 package cachestore
 
 import (
-	"encoding/gob"
+	"context"
+	"errors"
+	"reflect"
 	"time"
 
-	"appengine"
-	"appengine/datastore"
-	"appengine/memcache"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
 )
 
-var Debug = false // If true, print debug info
+// Debug prints debug info to the App Engine request log if true. Like the other variables, it's read by New, so it
+// applies to Cachestores created after it's set and to the package's functions.
+//
+// Deprecated: Debug can't be changed safely while cachestore is in use, use SetLogger(AppEngineLogger) instead.
+var Debug = false
+
+var (
+	Expiration   time.Duration // Expiration of cached items, zero means no expiration
+	DefaultCodec = Gob         // Codec used to encode cached items
+	KeyPrefix    string        // Prefix of memcache keys, change it to invalidate all cached items
+	Version      uint16        // Version of cached items, change it to invalidate items cached with other versions
+
+	// ItemFlags are set in the flags of the memcache items cachestore writes, for example to tell them apart from other
+	// systems' items. cachestore uses the flags' lowest 5 bits itself and stores Version in the highest 16, so only
+	// the bits of 0xffe0 are kept. Items are read whatever their ItemFlags, so changing them doesn't invalidate any.
+	ItemFlags uint32
+
+	// KeyFunc encodes datastore keys in memcache keys, after KeyPrefix, nil means Key.Encode. Key.Encode's keys grow
+	// with the ancestor path and can exceed memcache's 250 byte limit, a hash of them is short whatever the path. Keys
+	// that collide share one cached entity, so a hash must be long enough to make that unlikely, like sha256. KeyFunc
+	// mustn't return "cachestore.generation" or keys starting with "query:", "count:" or "load:", which cachestore uses
+	// itself. Like KeyPrefix, it must be the same wherever the same entities are cached, or writes won't evict them.
+	KeyFunc func(*datastore.Key) string
+
+	ExpirationJitter float64 // Fraction of expirations by which they're randomly lengthened or shortened, at most 1
+
+	KindExpirations map[string]time.Duration // Expirations of entities by kind, overriding Expiration, zero means none
+
+	RefreshAfter time.Duration // Age after which cached entities are reloaded from datastore when read, zero means never
+
+	// SlowOpThreshold is the duration from which memcache and datastore operations are logged, with their kind, their
+	// backend, their number of keys, their duration and whether they failed, but not their entities. Zero means none.
+	SlowOpThreshold time.Duration
+
+	// SlowOpLogger logs the slow operations, apart from the debug info so that logging them doesn't also log entities.
+	// nil means App Engine's request log, as warnings.
+	SlowOpLogger Logger
+
+	Compress        = false // If true, compress cached items of at least CompressMinSize bytes
+	CompressMinSize = 1024  // Size below which compression isn't worth it
+
+	CompressIf func(size int) bool // Whether to compress a cached item of size bytes, nil means use Compress
+
+	QueryExpiration = time.Minute // Expiration of cached query results
+
+	WriteThrough = false // If true, Put and PutMulti cache the entities they write instead of removing them from memcache
+
+	IgnoreFieldMismatch = false // If true, loading entities into structs without some of their fields isn't an error
+
+	MemcacheTimeout   time.Duration // Timeout of memcache calls, after which reads fall back to datastore, zero means none
+	MemcacheBatchSize = 1000        // Maximum number of keys per memcache call, zero means no maximum
+
+	// MemcacheNamespace is the memcache namespace cachestore caches entities in, whatever the namespace of the context,
+	// so that they're kept apart from other memcache items. The default is memcache's default namespace. Datastore
+	// calls still use the context's namespace, which encoded keys include, so entities of different namespaces don't
+	// collide. Changing it invalidates everything cached, like KeyPrefix.
+	MemcacheNamespace string
+
+	// Memcache is the backend entities are cached in, nil means App Engine's memcache. Set it to ShardedMemcache
+	// to spread entities across several memcache servers. MemcacheNamespace only applies to App Engine's memcache.
+	Memcache MemcacheBackend
+
+	MemcacheRetries      int                     // Number of times memcache calls failing with ErrServerError are retried
+	MemcacheRetryBackoff = 10 * time.Millisecond // Wait before the first retry, doubled before each of the next
+
+	// MemcacheReadOnly, if true, stops cachestore writing to memcache while still reading it, for example while
+	// investigating corrupt cached entities. Reads cache nothing, like with a ReadOnly context, so they read datastore
+	// for every entity that isn't cached already. Writes don't remove entities from memcache either, so entities
+	// written while it's set are read stale from memcache until they expire, or until Flush. Flush still works.
+	MemcacheReadOnly = false
+
+	LocalCache *LRU // In-process cache of entities checked before memcache, nil means none
+
+	UncachedKinds map[string]bool // Kinds of entities that are read from and written to datastore without caching
+
+	// OnEvict, unless it's nil, is called with the memcache keys of the entities cachestore removes from memcache, like
+	// the ones written by Put or Delete, even if removing them fails. Use it to tell other systems caching the entities
+	// about writes. Put with WriteThrough caches entities instead, so it only calls OnEvict if caching them fails, and
+	// writes in a RunInTransaction transaction call it once the transaction commits.
+	OnEvict func(keys []string)
+
+	// WriteBehindQueue enqueues the tasks of PutMultiBehind, which must eventually call RunWriteBehind with task, nil
+	// means PutMultiBehind fails. Use a durable queue, for example on App Engine, with
+	// delay.Func("cachestore.RunWriteBehind", cachestore.RunWriteBehind) as f:
+	//	cachestore.WriteBehindQueue = func(c context.Context, task []byte) error { return f.Call(c, task) }
+	WriteBehindQueue func(c context.Context, task []byte) error
+)
+
+// Cachestore caches entities in memcache like the package's functions, with its own configuration. The fields
+// configure it like the package-level variables of the same names configure the functions, which use a Cachestore
+// configured by the variables.
+//
+// The zero value caches with no expiration using Gob. Cachestores with different KeyPrefixes don't share cached items.
+// A Cachestore shouldn't be changed while it's in use.
+type Cachestore struct {
+	Expiration time.Duration
+	Codec      Codec // Gob if nil
+	KeyPrefix  string
+	Version    uint16
+
+	ItemFlags uint32
+
+	KeyFunc func(*datastore.Key) string // Key.Encode if nil
+
+	ExpirationJitter float64
+
+	KindExpirations map[string]time.Duration
+
+	RefreshAfter time.Duration
+
+	SlowOpThreshold time.Duration
+	SlowOpLogger    Logger // warnings in App Engine's request log if nil
+
+	Compress        bool
+	CompressMinSize int
+	CompressIf      func(size int) bool
+
+	QueryExpiration time.Duration
+
+	WriteThrough bool
+
+	IgnoreFieldMismatch bool
+
+	MemcacheTimeout   time.Duration
+	MemcacheBatchSize int
+	MemcacheReadOnly  bool
+	MemcacheNamespace string
+
+	Memcache MemcacheBackend // App Engine's memcache if nil
+
+	MemcacheRetries      int
+	MemcacheRetryBackoff time.Duration
+
+	LocalCache *LRU
+
+	UncachedKinds map[string]bool
+
+	OnEvict func(keys []string)
 
-func init() {
-	// register basic datastore types
-	gob.Register(time.Time{})
-	gob.Register(datastore.Key{})
+	WriteBehindQueue func(c context.Context, task []byte) error
+
+	Logger Logger // Logger of debug info, nil means the one set by SetLogger
+}
+
+// New returns a Cachestore configured like the package-level variables currently are.
+func New() *Cachestore {
+	return &Cachestore{
+		Expiration:           Expiration,
+		Codec:                DefaultCodec,
+		KeyPrefix:            KeyPrefix,
+		Version:              Version,
+		ItemFlags:            ItemFlags,
+		KeyFunc:              KeyFunc,
+		ExpirationJitter:     ExpirationJitter,
+		KindExpirations:      KindExpirations,
+		RefreshAfter:         RefreshAfter,
+		SlowOpThreshold:      SlowOpThreshold,
+		SlowOpLogger:         SlowOpLogger,
+		Compress:             Compress,
+		CompressMinSize:      CompressMinSize,
+		CompressIf:           CompressIf,
+		QueryExpiration:      QueryExpiration,
+		WriteThrough:         WriteThrough,
+		IgnoreFieldMismatch:  IgnoreFieldMismatch,
+		MemcacheTimeout:      MemcacheTimeout,
+		MemcacheBatchSize:    MemcacheBatchSize,
+		MemcacheReadOnly:     MemcacheReadOnly,
+		MemcacheNamespace:    MemcacheNamespace,
+		Memcache:             Memcache,
+		MemcacheRetries:      MemcacheRetries,
+		MemcacheRetryBackoff: MemcacheRetryBackoff,
+		LocalCache:           LocalCache,
+		UncachedKinds:        UncachedKinds,
+		OnEvict:              OnEvict,
+		WriteBehindQueue:     WriteBehindQueue,
+		Logger:               debugLogger(),
+	}
+}
+
+// debugLogger returns the Logger New gives Cachestores: AppEngineLogger if Debug is set, otherwise nil for the one set
+// by SetLogger.
+func debugLogger() Logger {
+	if Debug {
+		return AppEngineLogger
+	}
+	return nil
+}
+
+// defaultCachestore returns the Cachestore the package's functions use. It's created for every call so that changes
+// to the package-level variables take effect immediately.
+func defaultCachestore() *Cachestore {
+	return New()
+}
+
+// codec returns the Codec s encodes cached items with.
+func (s *Cachestore) codec() Codec {
+	if s.Codec == nil {
+		return Gob
+	}
+	return s.Codec
+}
+
+// Get loads the entity stored for key into dst using the default Cachestore. See Cachestore.Get.
+func Get(c context.Context, key *datastore.Key, dst interface{}) error {
+	return defaultCachestore().Get(c, key, dst)
+}
+
+// GetMulti is a batch version of Get. See Cachestore.GetMulti.
+func GetMulti(c context.Context, key []*datastore.Key, dst interface{}) error {
+	return defaultCachestore().GetMulti(c, key, dst)
+}
+
+// Put saves the entity src into datastore with key using the default Cachestore. See Cachestore.Put.
+func Put(c context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	return defaultCachestore().Put(c, key, src)
+}
+
+// PutMulti is a batch version of Put. See Cachestore.PutMulti.
+func PutMulti(c context.Context, key []*datastore.Key, src interface{}) ([]*datastore.Key, error) {
+	return defaultCachestore().PutMulti(c, key, src)
+}
+
+// Delete deletes the entity for key from memcache and datastore using the default Cachestore. See
+// Cachestore.Delete.
+func Delete(c context.Context, key *datastore.Key) error {
+	return defaultCachestore().Delete(c, key)
+}
+
+// DeleteMulti is a batch version of Delete. See Cachestore.DeleteMulti.
+func DeleteMulti(c context.Context, key []*datastore.Key) error {
+	return defaultCachestore().DeleteMulti(c, key)
+}
+
+// GetAndDelete loads the entity for key into dst and deletes it using the default Cachestore. See
+// Cachestore.GetAndDelete.
+func GetAndDelete(c context.Context, key *datastore.Key, dst interface{}) error {
+	return defaultCachestore().GetAndDelete(c, key, dst)
 }
 
 // Get loads the entity stored for key (from memcached if it has been cached, datastore otherwise) into dst,
-// which must be a struct pointer or implement PropertyLoadSaver. If there is no such entity for the key,
-// Get returns ErrNoSuchEntity.
+// which must be a struct pointer or implement PropertyLoadSaver, like a *PropertyList. Get returns
+// ErrInvalidEntityType for other dsts, including a PropertyList, without reading memcache or datastore. If there is
+// no such entity for the key, Get returns ErrNoSuchEntity. Get returns ErrNoSuchEntity for incomplete keys without
+// reading memcache or datastore.
 //
 // The values of dst's unmatched struct fields are not modified. In particular, it is recommended to pass either
 // a pointer or a zero valued struct on each Get call.
 //
 // ErrFieldMismatch is returned when a field is to be loaded into a different type than the one it was stored from,
 // or when a field is missing or unexported in the destination struct. ErrFieldMismatch is only returned if dst is
-// a struct pointer.
-func Get(c appengine.Context, key *datastore.Key, dst interface{}) error {
-	err := GetMulti(c, []*datastore.Key{key}, []interface{}{dst})
+// a struct pointer, and not at all if IgnoreFieldMismatch is set, in which case the fields that do match are loaded.
+// An entity that mismatches when it's read from datastore isn't cached, since caching the struct it was loaded into
+// would lose its other fields.
+func (s *Cachestore) Get(c context.Context, key *datastore.Key, dst interface{}) error {
+	if !isEntity(reflect.ValueOf(dst)) {
+		return datastore.ErrInvalidEntityType
+	}
+	err := s.GetMulti(c, []*datastore.Key{key}, []interface{}{dst})
 	if me, ok := err.(appengine.MultiError); ok {
 		return me[0]
 	}
@@ -42,7 +284,31 @@ func Get(c appengine.Context, key *datastore.Key, dst interface{}) error {
 }
 
 // GetMulti is a batch version of Get. Cached values are returned from memcache, uncached values are returned from
-// datastore and memcached for next time.
+// datastore and memcached for next time. Only the keys that missed memcache are read from datastore, and a key
+// repeated in key is read once and loaded into each of its dst elements. Cached entities that can't be decoded count
+// as misses, and are replaced in memcache with what's read from datastore. Failing to cache entities doesn't fail
+// GetMulti: the failures are logged and counted by Stats.
+//
+// If loading some entities fails GetMulti returns an appengine.MultiError with the error of each key, whether it
+// was read from memcache or datastore. Errors reading memcache are never returned for a key read from datastore
+// instead: its error is datastore's, nil if the entity was loaded, ErrNoSuchEntity if it doesn't exist, and
+// ErrFieldMismatch (unless IgnoreFieldMismatch) if it was loaded into a struct without some of its fields. An
+// ErrFieldMismatch decoding a cached entity is returned like datastore's. Other datastore errors are returned as is,
+// except that failing to read the missing entities doesn't fail the ones read from memcache: unless every key missed,
+// a datastore error that isn't an appengine.MultiError is returned for each missing key in an appengine.MultiError.
+// Likewise if c's deadline passes or c is canceled while reading memcache, the missing entities aren't read from
+// datastore and c.Err() is returned for them, so a request short of time still gets the cached entities.
+//
+// On error dst may be partly loaded. In an appengine.MultiError, the elements of the keys whose error is nil are
+// loaded, and those whose error is ErrFieldMismatch are loaded with the fields that matched, like datastore loads
+// them. The other elements, and all of them if the error isn't an appengine.MultiError, may be unchanged or partly
+// loaded. Errors found before reading anything, like ErrInvalidEntityType or key and dst having different lengths,
+// leave dst unchanged. Use an AllOrNothing context to leave dst unchanged whatever the error.
+//
+// Keys are locked in memcache while they're read from datastore, and the values read are only cached if the locks
+// are still there, so a Put or Delete made during the read can't be overwritten with the value it replaced. Cached
+// values are as consistent as memcache: if memcache loses a write's eviction or a lock expires, stale values may be
+// cached. Use a Strong context to read datastore directly.
 //
 // dst must be a []S, []*S, []I or []P, for some struct type S, some interface type I, or some non-interface
 // non-pointer type P such that P or *P implements PropertyLoadSaver. If an []I, each element must be a valid
@@ -50,76 +316,438 @@ func Get(c appengine.Context, key *datastore.Key, dst interface{}) error {
 //
 // As a special case, PropertyList is an invalid type for dst, even though a PropertyList is a slice of structs.
 // It is treated as invalid to avoid being mistakenly passed when []PropertyList was intended.
-func GetMulti(c appengine.Context, key []*datastore.Key, dst interface{}) error {
+func (s *Cachestore) GetMulti(c context.Context, key []*datastore.Key, dst interface{}) error {
+	return s.getEntities(c, key, dst, nil)
+}
+
+// getEntities implements GetMulti, also setting the source of each entity in sources unless sources is nil.
+func (s *Cachestore) getEntities(c context.Context, key []*datastore.Key, dst interface{}, sources []Source) error {
+	if err := checkMultiLen(key, dst); err != nil {
+		return err
+	}
 	if len(key) == 0 {
 		return nil
 	}
+	if isAllOrNothing(c) {
+		return s.getAllOrNothing(c, key, dst, sources)
+	}
+	if complete := completeIndexes(key); len(complete) < len(key) {
+		return s.getComplete(c, key, dst, complete, sources)
+	}
+	if transactionFromContext(c) != nil || isBypass(c) {
+		return s.getFromDatastore(c, key, dst, sources)
+	}
 	// check cache
-	encodedKeys := encodeKeys(key)
-	itemMap, errm := memcache.GetMulti(c, encodedKeys)
-	if len(itemMap) != len(key) {
-		// TODO benchmark loading all vs loading missing
-		// load from datastore
-		errd := datastore.GetMulti(c, key, dst)
-		if Debug {
-			c.Debugf("reading from datastore: %#v", dst)
-		}
-		if errd != nil {
-			return errd
-		}
-		// cache for next time
-		errm = cache(key, dst, c)
+	encodedKeys := s.encodeKeys(key)
+	strong := isStrong(c)
+	var itemMap map[string]*memcache.Item
+	var generation uint64
+	var errc error
+	if strong {
+		// read everything from datastore, but still cache it
+		itemMap = map[string]*memcache.Item{}
+		generation, errc = s.getGeneration(c)
+	} else if cachedKeys := s.cachedKeys(key, encodedKeys); len(cachedKeys) > 0 {
+		itemMap, generation, errc = s.getEntityItems(c, cachedKeys)
+	} else {
+		itemMap = map[string]*memcache.Item{}
+	}
+	if errc != nil {
+		// read everything from datastore
+		s.debugf(c, "reading from memcache: %v", errc)
+	}
+	missing, errs := s.decodeItems(key, itemMap, dst)
+	s.debugf(c, "reading from memcache: %#v", dst)
+	if s.RefreshAfter > 0 && !s.readOnly(c) {
+		s.refreshAhead(c, key, encodedKeys, itemMap, dst, errs)
+	}
+	var errm error
+	if len(missing) == 0 {
+		count(c, len(key), 0, 0)
+	} else if err := c.Err(); err != nil {
+		// c's deadline passed reading memcache, so return what was read without waiting for datastore
+		count(c, len(key), len(missing), 0)
+		if len(missing) == len(key) {
+			return err
+		}
+		for _, j := range missing {
+			errs[j] = err
+		}
 	} else {
-		errm = decodeItems(key, itemMap, dst)
-		if Debug {
-			c.Debugf("reading from memcache: %#v", dst)
+		corrupt := *new([]*datastore.Key)
+		for _, j := range missing {
+			if _, ok := errs[j].(*ErrCacheDecode); ok {
+				corrupt = append(corrupt, key[j])
+			}
+			if errs[j] != nil {
+				s.debugf(c, "reading from memcache: %v: %v", key[j], errs[j])
+				errs[j] = nil
+			}
+		}
+		if len(corrupt) > 0 {
+			// remove what can't be decoded, so that it's replaced with what's read from datastore
+			if err := s.uncache(corrupt, c); err != nil {
+				s.debugf(c, "removing from memcache: %v", err)
+			}
+		}
+		// load missing from datastore, sharing loads of the same keys with concurrent calls
+		var loads map[int]*load
+		var lead, follow []int
+		if strong {
+			// strong reads can't share loads that may have started before them
+			loads, lead, follow = startAlone(encodedKeys, missing)
+		} else {
+			loads, lead, follow = inflight.start(encodedKeys, missing)
+		}
+		count(c, len(key), len(missing), len(lead))
+		defer inflight.finishAll(encodedKeys, lead, loads)
+		var errd error
+		var items []*memcache.Item
+		var locks map[string]*memcache.Item
+		readOnly := s.readOnly(c)
+		if len(lead) > 0 {
+			if errc == nil && !readOnly {
+				// lock before reading, so that writes made while reading keep the values read from being cached
+				leadKeys := make([]string, 0, len(lead))
+				for _, j := range lead {
+					if !s.uncached(key[j]) {
+						leadKeys = append(leadKeys, encodedKeys[j])
+					}
+				}
+				if len(leadKeys) > 0 {
+					locks = s.lockItems(c, leadKeys)
+				}
+			}
+			errd = s.loadMulti(c, key, dst, lead, loads, errs)
+			s.debugf(c, "reading from datastore: %#v", dst)
+			items, errm = s.shareLoads(key, encodedKeys, lead, loads)
+		}
+		s.waitLoads(key, dst, follow, loads, errs)
+		if _, ok := errd.(appengine.MultiError); errd != nil && !ok {
+			if len(missing) == len(key) {
+				return errd
+			}
+			// the entities read from memcache are still valid, only the missing ones failed
+			for _, j := range lead {
+				errs[j] = errd
+			}
+		}
+		// cache the entities that were loaded for next time, unless memcache is failing
+		if errc == nil && errm == nil && !readOnly {
+			errm = s.casItems(c, items, locks, generation)
+		} else if errm != nil {
+			s.debugf(c, "encoding: %v", errm)
+		}
+		// what was read is still valid if caching it failed
+		countCacheErrors(countItemErrors(len(items), errm))
+	}
+	if sources != nil {
+		setSources(sources, missing, errs)
+	}
+	for _, err := range errs {
+		if err != nil {
+			return errs
+		}
+	}
+	return nil
+}
+
+// loadMulti reads the entities for the keys at the lead indexes from datastore, sets their properties or errors in
+// their loads, and loads them into dst. If datastore returns an appengine.MultiError its errors are copied into errs
+// at their original indexes and errs is returned.
+func (s *Cachestore) loadMulti(c context.Context, key []*datastore.Key, dst interface{}, lead []int, loads map[int]*load, errs appengine.MultiError) error {
+	leadKey := make([]*datastore.Key, len(lead))
+	for i, j := range lead {
+		leadKey[i] = key[j]
+	}
+	properties := make([]datastore.PropertyList, len(lead))
+	start := time.Now()
+	err := datastoreBackend.GetMulti(c, leadKey, properties)
+	s.logSlowOp(c, "get", "datastore", len(leadKey), start, err)
+	me, multi := err.(appengine.MultiError)
+	if err != nil && !multi {
+		for _, j := range lead {
+			loads[j].err = err
+		}
+		return err
+	}
+	v := reflect.ValueOf(dst)
+	multiArgType, _ := checkMultiArg(v)
+	for i, j := range lead {
+		l := loads[j]
+		if multi && me[i] != nil {
+			l.err, errs[j] = me[i], me[i]
+			continue
+		}
+		l.properties, l.err = properties[i], nil
+		// a copy, since the properties are shared with the calls following the load
+		errs[j] = s.loadElem(v, j, multiArgType, key[j], append([]datastore.Property(nil), properties[i]...))
+	}
+	if multi {
+		return errs
+	}
+	return nil
+}
+
+// completeIndexes returns the indexes of the keys that are complete.
+func completeIndexes(key []*datastore.Key) []int {
+	complete := make([]int, 0, len(key))
+	for i, k := range key {
+		if !k.Incomplete() {
+			complete = append(complete, i)
+		}
+	}
+	return complete
+}
+
+// getComplete gets the entities for the keys at the complete indexes into dst. The other keys are incomplete, so
+// there can't be entities for them and their errors are ErrNoSuchEntity.
+func (s *Cachestore) getComplete(c context.Context, key []*datastore.Key, dst interface{}, complete []int, sources []Source) error {
+	v := reflect.ValueOf(dst)
+	completeKey, completeDst := subset(key, v, complete)
+	var completeSources []Source
+	if sources != nil {
+		completeSources = make([]Source, len(complete))
+	}
+	err := s.getEntities(c, completeKey, completeDst.Interface(), completeSources)
+	for i, j := range complete {
+		if sources != nil {
+			sources[j] = completeSources[i]
+		}
+	}
+	me, ok := err.(appengine.MultiError)
+	if err != nil && !ok {
+		return err
+	}
+	errs := make(appengine.MultiError, len(key))
+	for i := range errs {
+		errs[i] = datastore.ErrNoSuchEntity
+	}
+	for i, j := range complete {
+		v.Index(j).Set(completeDst.Index(i))
+		errs[j] = nil
+		if ok {
+			errs[j] = me[i]
+		}
+	}
+	return errs
+}
+
+// subset returns the keys at the given indexes and a new slice of the same type as v holding the matching elements.
+func subset(key []*datastore.Key, v reflect.Value, index []int) ([]*datastore.Key, reflect.Value) {
+	subKey := make([]*datastore.Key, len(index))
+	subV := reflect.MakeSlice(v.Type(), len(index), len(index))
+	for i, j := range index {
+		subKey[i] = key[j]
+		subV.Index(i).Set(v.Index(j))
+	}
+	return subKey, subV
+}
+
+var errMultiArgLength = errors.New("cachestore: key and entity slices have different length")
+
+// checkMultiLen returns an error unless v is a slice of entities with one element per key. Invalid elements of an
+// []I are reported in an appengine.MultiError.
+func checkMultiLen(key []*datastore.Key, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	multiArgType, _ := checkMultiArg(rv)
+	if multiArgType == multiArgTypeInvalid {
+		return datastore.ErrInvalidEntityType
+	}
+	if rv.Len() != len(key) {
+		return errMultiArgLength
+	}
+	if multiArgType == multiArgTypeInterface {
+		// each element of an []I must be a valid entity for Get
+		var multiErr appengine.MultiError
+		for i := 0; i < rv.Len(); i++ {
+			if !isEntity(rv.Index(i).Elem()) {
+				if multiErr == nil {
+					multiErr = make(appengine.MultiError, rv.Len())
+				}
+				multiErr[i] = datastore.ErrInvalidEntityType
+			}
+		}
+		if multiErr != nil {
+			return multiErr
 		}
 	}
-	return errm
+	return nil
+}
+
+// isEntity returns whether v is a non-nil struct pointer or implements PropertyLoadSaver.
+func isEntity(v reflect.Value) bool {
+	if !v.IsValid() {
+		return false
+	}
+	if v.Type().Implements(typeOfPropertyLoadSaver) {
+		return v.Kind() != reflect.Ptr || !v.IsNil()
+	}
+	return v.Kind() == reflect.Ptr && !v.IsNil() && v.Elem().Kind() == reflect.Struct
 }
 
 // Put saves the entity src into datastore with key, and removes it from memcache (so that it may be lazy-loaded).
 // src must be a struct pointer or implement PropertyLoadSaver; if a struct pointer then any unexported fields
 // of that struct will be skipped. If k is an incomplete key, the returned key will be a unique key generated
 // by the datastore.
-func Put(c appengine.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
-	k, err := PutMulti(c, []*datastore.Key{key}, []interface{}{src})
-	if err != nil {
-		if me, ok := err.(appengine.MultiError); ok {
-			return nil, me[0]
-		}
+//
+// If WriteThrough is true, Put caches src in memcache instead of removing it, unless c is a RunInTransaction context.
+// Entities written in a transaction started with datastore.RunInTransaction would be cached before it commits, so use
+// RunInTransaction with WriteThrough.
+func (s *Cachestore) Put(c context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	k, err := s.PutMulti(c, []*datastore.Key{key}, []interface{}{src})
+	if me, ok := err.(appengine.MultiError); ok {
+		err = me[0]
+	}
+	if len(k) == 0 {
 		return nil, err
 	}
-	return k[0], nil
+	return k[0], err
 }
 
-// PutMulti is a batch version of Put.
+// PutMulti is a batch version of Put. Batches larger than datastore's limit of 500 entities are put in several
+// calls, whose errors are merged.
 //
-// src must satisfy the same conditions as the dst argument to GetMulti.
-func PutMulti(c appengine.Context, key []*datastore.Key, src interface{}) ([]*datastore.Key, error) {
-	if Debug {
-		c.Debugf("writing to datastore: %#v", src)
+// src must satisfy the same conditions as the dst argument to GetMulti. If writing to datastore succeeds but removing
+// the entities from memcache fails, the memcache error is returned.
+func (s *Cachestore) PutMulti(c context.Context, key []*datastore.Key, src interface{}) ([]*datastore.Key, error) {
+	return s.putEntities(c, key, src, nil)
+}
+
+// putEntities implements PutMulti, caching src with opts if WriteThrough is set.
+func (s *Cachestore) putEntities(c context.Context, key []*datastore.Key, src interface{}, opts []PutOption) ([]*datastore.Key, error) {
+	if err := checkMultiLen(key, src); err != nil {
+		return nil, err
+	}
+	s.debugf(c, "writing to datastore: %#v", src)
+	start := time.Now()
+	key, errd := putMulti(c, key, src)
+	s.logSlowOp(c, "put", "datastore", len(key), start, errd)
+	var errm error
+	if s.WriteThrough && errd == nil && transactionFromContext(c) == nil && !isBypass(c) {
+		// cache src with the keys datastore allocated for incomplete keys
+		s.removeLocal(c, s.encodeKeys(key))
+		if err := s.cache(key, src, opts, c); err != nil {
+			s.debugf(c, "writing to memcache: %v", err)
+			errm = s.evictPut(c, key, opts)
+		}
+	} else {
+		errm = s.evictPut(c, key, opts)
+	}
+	if errd != nil {
+		return key, errd
 	}
-	key, errd := datastore.PutMulti(c, key, src)
-	memcache.DeleteMulti(c, encodeKeys(key))
-	return key, errd
+	return key, errm
+}
+
+// maxPutBatchSize is the maximum number of entities datastore can put in one call.
+const maxPutBatchSize = 500
+
+// maxGetBatchSize is the maximum number of entities datastore can get in one call.
+const maxGetBatchSize = 1000
+
+// putMulti puts the entities of src to datastore in batches of at most maxPutBatchSize. It returns the keys of the
+// entities in the order of key, with the keys datastore allocated for the incomplete keys of the batches that
+// succeeded.
+func putMulti(c context.Context, key []*datastore.Key, src interface{}) ([]*datastore.Key, error) {
+	v := reflect.ValueOf(src)
+	putKey := make([]*datastore.Key, len(key))
+	copy(putKey, key)
+	err := batch(len(key), maxPutBatchSize, func(i, j int) error {
+		k, err := datastoreBackend.PutMulti(c, key[i:j], v.Slice(i, j).Interface())
+		if len(k) == j-i {
+			copy(putKey[i:j], k)
+		}
+		return err
+	})
+	return putKey, err
 }
 
 // Delete deletes the entity for the given key from memcache and datastore.
-func Delete(c appengine.Context, key *datastore.Key) error {
-	err := DeleteMulti(c, []*datastore.Key{key})
+func (s *Cachestore) Delete(c context.Context, key *datastore.Key) error {
+	err := s.DeleteMulti(c, []*datastore.Key{key})
 	if me, ok := err.(appengine.MultiError); ok {
 		return me[0]
 	}
 	return err
 }
 
-// DeleteMulti is a batched version of Delete.
-func DeleteMulti(c appengine.Context, key []*datastore.Key) error {
-	errm := memcache.DeleteMulti(c, encodeKeys(key))
-	errd := datastore.DeleteMulti(c, key)
+// GetAndDelete loads the entity for key into dst like Get, then deletes it from memcache and datastore like Delete.
+// Nothing is deleted if Get fails, including when there's no such entity or it can't be loaded into dst.
+//
+// Concurrent calls may load the same entity before either deletes it. To load it at most once, call GetAndDelete in
+// RunInTransaction.
+func (s *Cachestore) GetAndDelete(c context.Context, key *datastore.Key, dst interface{}) error {
+	if err := s.Get(c, key, dst); err != nil {
+		return err
+	}
+	return s.Delete(c, key)
+}
+
+// DeleteMulti is a batched version of Delete. Incomplete keys are skipped, since there can't be entities for them,
+// and so are keys without entities, which datastore reports as ErrNoSuchEntity.
+func (s *Cachestore) DeleteMulti(c context.Context, key []*datastore.Key) error {
+	complete, errd, errm := s.deleteEntities(c, key)
 	if errd != nil {
-		return errd
+		return remapErrors(errd, complete, len(key))
+	}
+	return remapErrors(errm, complete, len(key))
+}
+
+// deleteEntities deletes the entities for the complete keys in key from datastore and memcache. It returns the
+// indexes of the complete keys, and the errors deleting them from datastore and memcache, whose appengine.MultiErrors
+// are indexed like the complete keys.
+func (s *Cachestore) deleteEntities(c context.Context, key []*datastore.Key) ([]int, error, error) {
+	complete := completeIndexes(key)
+	if len(complete) == 0 {
+		return complete, nil, nil
+	}
+	completeKey := key
+	if len(complete) < len(key) {
+		completeKey = make([]*datastore.Key, len(complete))
+		for i, j := range complete {
+			completeKey[i] = key[j]
+		}
+	}
+	start := time.Now()
+	errd := ignoreNoSuchEntity(datastoreBackend.DeleteMulti(c, completeKey))
+	s.logSlowOp(c, "delete", "datastore", len(completeKey), start, errd)
+	errm := s.evict(c, completeKey)
+	return complete, errd, errm
+}
+
+// ignoreNoSuchEntity returns err without the ErrNoSuchEntity errors of an appengine.MultiError, or nil if it has no
+// others. Deleting an entity that doesn't exist succeeds.
+func ignoreNoSuchEntity(err error) error {
+	me, ok := err.(appengine.MultiError)
+	if !ok {
+		return err
+	}
+	var multiErr appengine.MultiError
+	for i, err := range me {
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			if multiErr == nil {
+				multiErr = make(appengine.MultiError, len(me))
+			}
+			multiErr[i] = err
+		}
+	}
+	if multiErr != nil {
+		return multiErr
+	}
+	return nil
+}
+
+// remapErrors returns err, an error for the keys at the complete indexes of n keys, with an appengine.MultiError
+// remapped to the indexes of all n keys.
+func remapErrors(err error, complete []int, n int) error {
+	me, ok := err.(appengine.MultiError)
+	if !ok || len(complete) == n {
+		return err
+	}
+	errs := make(appengine.MultiError, n)
+	for i, j := range complete {
+		errs[j] = me[i]
 	}
-	return errm
+	return errs
 }
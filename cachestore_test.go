@@ -1,29 +1,71 @@
 package cachestore
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
+	"flag"
 	"fmt"
+	"os"
 	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	"appengine"
-	"appengine/aetest"
-	"appengine/datastore"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/aetest"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
 )
 
-var c = must(aetest.NewContext(nil))
+var c context.Context
 
-func must(c aetest.Context, err error) aetest.Context {
-	if err != nil {
-		panic(err)
+// aetestContext is whether c is an aetest context. Without dev_appserver.py, or with -short, c is a plain context and
+// only the tests that don't call appengine's services, like the ones against the fake backends, run.
+var aetestContext bool
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Short() {
+		ctx, done, err := aetest.NewContext()
+		if err == nil {
+			c, aetestContext = ctx, true
+			code := m.Run()
+			done()
+			os.Exit(code)
+		}
+		fmt.Fprintf(os.Stderr, "skipping the tests that need aetest: %v\n", err)
+	}
+	c = context.Background()
+	os.Exit(m.Run())
+}
+
+// requireAetest skips the test calling it unless c is an aetest context.
+func requireAetest(tb testing.TB) {
+	if !aetestContext {
+		tb.Skip("needs an aetest context")
 	}
-	return c
 }
 
 func init() {
 	gob.Register(*new(Struct))
 }
 
+// failingContext returns a context that fails all API calls to service.method
+func failingContext(service, method string) context.Context {
+	return appengine.WithAPICallFunc(c, func(ctx context.Context, s, m string, in, out proto.Message) error {
+		if s == service && m == method {
+			return errFailingContext
+		}
+		return appengine.APICall(ctx, s, m, in, out)
+	})
+}
+
+var errFailingContext = errors.New("failing context")
+
 type Struct struct {
 	I int
 }
@@ -32,24 +74,23 @@ type PropertyLoadSaver struct {
 	S string
 }
 
-func (p *PropertyLoadSaver) Load(c <-chan datastore.Property) error {
-	if err := datastore.LoadStruct(p, c); err != nil {
+func (p *PropertyLoadSaver) Load(ps []datastore.Property) error {
+	if err := datastore.LoadStruct(p, ps); err != nil {
 		return err
 	}
 	p.S += ".load"
 	return nil
 }
 
-func (p *PropertyLoadSaver) Save(c chan<- datastore.Property) error {
-	defer close(c)
-	c <- datastore.Property{
+func (p *PropertyLoadSaver) Save() ([]datastore.Property, error) {
+	return []datastore.Property{{
 		Name:  "S",
 		Value: p.S + ".save",
-	}
-	return nil
+	}}, nil
 }
 
 func TestWithStruct(t *testing.T) {
+	requireAetest(t)
 	src := Struct{I: 3}
 	key := datastore.NewIncompleteKey(c, "Struct", nil)
 	// Put
@@ -73,11 +114,12 @@ func TestWithStruct(t *testing.T) {
 	}
 	err = Get(c, key, &dst)
 	if err != datastore.ErrNoSuchEntity {
-		t.Fatal("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
 	}
 }
 
 func TestWithStructArray(t *testing.T) {
+	requireAetest(t)
 	src := *new([]Struct)
 	key := *new([]*datastore.Key)
 	for i := 1; i < 11; i++ {
@@ -115,11 +157,196 @@ func TestWithStructArray(t *testing.T) {
 	}
 }
 
-// TODO test []*S
+func TestWithStructPointerArray(t *testing.T) {
+	requireAetest(t)
+	src := *new([]*Struct)
+	key := *new([]*datastore.Key)
+	for i := 1; i < 11; i++ {
+		src = append(src, &Struct{I: i})
+		key = append(key, datastore.NewIncompleteKey(c, "Struct", nil))
+	}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti from datastore, then from memcache
+	for i := 0; i < 2; i++ {
+		dst := make([]*Struct, len(src))
+		for j := range dst {
+			dst[j] = &Struct{}
+		}
+		err = GetMulti(c, key, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src, dst) {
+			t.Fatalf("expected=%#v actual=%#v", src, dst)
+		}
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = GetMulti(c, key, make([]*Struct, len(src)))
+	if me, ok := err.(appengine.MultiError); ok {
+		for _, e := range me {
+			if e != datastore.ErrNoSuchEntity {
+				t.Fatal(e)
+			}
+		}
+	} else {
+		t.Fatal(err)
+	}
+}
+
+func TestWithInterfaceArray(t *testing.T) {
+	requireAetest(t)
+	src := *new([]interface{})
+	key := *new([]*datastore.Key)
+	for i := 1; i < 11; i++ {
+		src = append(src, &Struct{I: i})
+		key = append(key, datastore.NewIncompleteKey(c, "Struct", nil))
+	}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti from datastore, then from memcache
+	for i := 0; i < 2; i++ {
+		dst := make([]interface{}, len(src))
+		for j := range dst {
+			dst[j] = &Struct{}
+		}
+		err = GetMulti(c, key, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src, dst) {
+			t.Fatalf("expected=%#v actual=%#v", src, dst)
+		}
+	}
+	// GetMulti into invalid elements
+	dst := []interface{}{&Struct{}, Struct{}, nil, (*Struct)(nil)}
+	err = GetMulti(c, key[:len(dst)], dst)
+	expected := appengine.MultiError{nil, datastore.ErrInvalidEntityType, datastore.ErrInvalidEntityType, datastore.ErrInvalidEntityType}
+	if !reflect.DeepEqual(expected, err) {
+		t.Fatalf("expected=%#v actual=%#v", expected, err)
+	}
+	// PutMulti invalid elements
+	_, err = PutMulti(c, key[:len(dst)], dst)
+	if !reflect.DeepEqual(expected, err) {
+		t.Fatalf("expected=%#v actual=%#v", expected, err)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetChecksDst(t *testing.T) {
+	requireAetest(t)
+	src := &Struct{I: 9}
+	// Put
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get into invalid dsts
+	var calls int64
+	ctx := appengine.WithAPICallFunc(c, func(ctx context.Context, service, method string, in, out proto.Message) error {
+		atomic.AddInt64(&calls, 1)
+		return appengine.APICall(ctx, service, method, in, out)
+	})
+	for _, dst := range []interface{}{datastore.PropertyList{}, Struct{}, nil, (*Struct)(nil)} {
+		err = Get(ctx, key, dst)
+		if err != datastore.ErrInvalidEntityType {
+			t.Fatalf("expected=%#v actual=%#v", datastore.ErrInvalidEntityType, err)
+		}
+	}
+	if calls != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, calls)
+	}
+	// Get into a *PropertyList from datastore, then from memcache
+	for i := 0; i < 2; i++ {
+		var dst datastore.PropertyList
+		err = Get(c, key, &dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := datastore.PropertyList{{Name: "I", Value: int64(9)}}
+		if !reflect.DeepEqual(expected, dst) {
+			t.Fatalf("expected=%#v actual=%#v", expected, dst)
+		}
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Counter is a non-struct PropertyLoadSaver
+type Counter int
 
-// TODO test []I
+func (n *Counter) Load(ps []datastore.Property) error {
+	for _, p := range ps {
+		if p.Name == "N" {
+			*n = Counter(p.Value.(int64))
+		}
+	}
+	return nil
+}
+
+func (n *Counter) Save() ([]datastore.Property, error) {
+	return []datastore.Property{{Name: "N", Value: int64(*n)}}, nil
+}
+
+func TestWithNonStructPropertyLoadSaverArray(t *testing.T) {
+	requireAetest(t)
+	src := *new([]Counter)
+	key := *new([]*datastore.Key)
+	for i := 1; i < 11; i++ {
+		src = append(src, Counter(i))
+		key = append(key, datastore.NewIncompleteKey(c, "Counter", nil))
+	}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti from datastore, then from memcache
+	for i := 0; i < 2; i++ {
+		dst := make([]Counter, len(src))
+		err = GetMulti(c, key, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src, dst) {
+			t.Fatalf("expected=%#v actual=%#v", src, dst)
+		}
+	}
+	// Get
+	dst := *new(Counter)
+	err = Get(c, key[0], &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst != src[0] {
+		t.Fatalf("expected=%#v actual=%#v", src[0], dst)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
 
 func TestWithPropertyLoadSaver(t *testing.T) {
+	requireAetest(t)
 	src := PropertyLoadSaver{}
 	key := datastore.NewIncompleteKey(c, "PropertyLoadSaver", nil)
 	// Put
@@ -143,11 +370,12 @@ func TestWithPropertyLoadSaver(t *testing.T) {
 	}
 	err = Get(c, key, &dst)
 	if err != datastore.ErrNoSuchEntity {
-		t.Fatal("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
 	}
 }
 
 func TestWithPropertyLoadSaverArray(t *testing.T) {
+	requireAetest(t)
 	src := *new([]PropertyLoadSaver)
 	key := *new([]*datastore.Key)
 	for i := 1; i < 11; i++ {
@@ -187,7 +415,54 @@ func TestWithPropertyLoadSaverArray(t *testing.T) {
 	}
 }
 
+// KeyNamed is a KeyLoader that remembers the name of its key
+type KeyNamed struct {
+	S    string
+	Name string `datastore:"-"`
+}
+
+func (l *KeyNamed) LoadKey(k *datastore.Key) error {
+	l.Name = k.StringID()
+	return nil
+}
+
+func (l *KeyNamed) Load(ps []datastore.Property) error {
+	return datastore.LoadStruct(l, ps)
+}
+
+func (l *KeyNamed) Save() ([]datastore.Property, error) {
+	return datastore.SaveStruct(l)
+}
+
+func TestWithKeyLoader(t *testing.T) {
+	requireAetest(t)
+	key := datastore.NewKey(c, "KeyNamed", "keyLoader", 0, nil)
+	// Put
+	_, err := Put(c, key, &KeyNamed{S: "s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &KeyNamed{S: "s", Name: "keyLoader"}
+	// Get from datastore, then from memcache
+	for i := 0; i < 2; i++ {
+		dst := &KeyNamed{}
+		err = Get(c, key, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, dst) {
+			t.Fatalf("expected=%#v actual=%#v", expected, dst)
+		}
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestGetFromMemcache(t *testing.T) {
+	requireAetest(t)
 	src := Struct{I: 3}
 	key := datastore.NewIncompleteKey(c, "Struct", nil)
 	// Put
@@ -221,11 +496,12 @@ func TestGetFromMemcache(t *testing.T) {
 	}
 	err = Get(c, key, &dst)
 	if err != datastore.ErrNoSuchEntity {
-		t.Fatal("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
 	}
 }
 
 func TestGetFromDatastore(t *testing.T) {
+	requireAetest(t)
 	src := Struct{I: 3}
 	key := datastore.NewIncompleteKey(c, "Struct", nil)
 	// Put
@@ -249,6 +525,1623 @@ func TestGetFromDatastore(t *testing.T) {
 	}
 	err = Get(c, key, &dst)
 	if err != datastore.ErrNoSuchEntity {
-		t.Fatal("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	requireAetest(t)
+	Expiration = time.Second
+	defer func() { Expiration = 0 }()
+	src := Struct{I: 3}
+	key := datastore.NewIncompleteKey(c, "Struct", nil)
+	// Put
+	key, err := Put(c, key, &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load memcache with Get
+	dst := *new(Struct)
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// remove from datastore
+	err = datastore.Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// wait for memcache to expire
+	time.Sleep(2 * Expiration)
+	// Get
+	err = Get(c, key, &dst)
+	if err != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+}
+
+func TestGetMultiPartialHit(t *testing.T) {
+	requireAetest(t)
+	src := *new([]Struct)
+	key := *new([]*datastore.Key)
+	for i := 1; i < 11; i++ {
+		src = append(src, Struct{I: i})
+		key = append(key, datastore.NewIncompleteKey(c, "Struct", nil))
+	}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load memcache with GetMulti
+	dst := make([]Struct, len(src))
+	err = GetMulti(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// evict odd keys from memcache
+	evicted := *new([]*datastore.Key)
+	for i := 1; i < len(key); i += 2 {
+		evicted = append(evicted, key[i])
+	}
+	err = memcache.DeleteMulti(c, defaultCachestore().encodeKeys(evicted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// update all keys in datastore only
+	changed := make([]Struct, len(src))
+	for i := range changed {
+		changed[i] = Struct{I: -src[i].I}
+	}
+	_, err = datastore.PutMulti(c, key, changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti should only read the evicted keys from datastore
+	dst = make([]Struct, len(src))
+	err = GetMulti(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, d := range dst {
+		expected := src[i]
+		if i%2 == 1 {
+			expected = changed[i]
+		}
+		if !reflect.DeepEqual(expected, d) {
+			t.Fatalf("i=%d expected=%#v actual=%#v", i, expected, d)
+		}
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetMultiPartialHitMultiError(t *testing.T) {
+	requireAetest(t)
+	src := Struct{I: 3}
+	key := datastore.NewIncompleteKey(c, "Struct", nil)
+	// Put
+	key, err := Put(c, key, &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load memcache with Get
+	dst := *new(Struct)
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti with an absent key
+	absent := datastore.NewKey(c, "Struct", "absent", 0, nil)
+	dsts := make([]Struct, 2)
+	err = GetMulti(c, []*datastore.Key{absent, key}, dsts)
+	me, ok := err.(appengine.MultiError)
+	if !ok {
+		t.Fatalf("expected appengine.MultiError actual=%#v", err)
+	}
+	if me[0] != datastore.ErrNoSuchEntity || me[1] != nil {
+		t.Fatalf("expected=[%#v <nil>] actual=%#v", datastore.ErrNoSuchEntity, me)
+	}
+	if !reflect.DeepEqual(src, dsts[1]) {
+		t.Fatalf("expected=%#v actual=%#v", src, dsts[1])
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetMultiPartialHitMultiErrorIndexes(t *testing.T) {
+	requireAetest(t)
+	src := []Struct{{0}, {1}, {2}, {3}, {4}, {5}, {6}}
+	key := make([]*datastore.Key, len(src))
+	for i := range key {
+		key[i] = datastore.NewIncompleteKey(c, "Struct", nil)
+	}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load memcache with Get for index 2, and delete index 5 from datastore
+	err = Get(c, key[2], &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Delete(c, key[5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti
+	dst := make([]Struct, len(key))
+	sources, err := GetMultiWithSource(c, key, dst)
+	expected := appengine.MultiError{nil, nil, nil, nil, nil, datastore.ErrNoSuchEntity, nil}
+	if !reflect.DeepEqual(expected, err) {
+		t.Fatalf("expected=%#v actual=%#v", expected, err)
+	}
+	if sources[2] != FromMemcache {
+		t.Fatalf("expected=%v actual=%v", FromMemcache, sources[2])
+	}
+	for i := range src {
+		if i != 5 && !reflect.DeepEqual(src[i], dst[i]) {
+			t.Fatalf("expected=%#v actual=%#v", src[i], dst[i])
+		}
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetMultiRepeatedKeys(t *testing.T) {
+	requireAetest(t)
+	src := &Struct{I: 3}
+	// Put
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		c     context.Context
+		reads uint64
+	}{
+		{c, 1},         // from datastore
+		{c, 0},         // from memcache
+		{Strong(c), 1}, // from datastore
+	}
+	for _, test := range tests {
+		// GetMulti with the key repeated
+		before := Stats()
+		dst := []interface{}{&Struct{}, &datastore.PropertyList{}, &Struct{}}
+		err = GetMulti(test.c, []*datastore.Key{key, key, key}, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src, dst[0]) || !reflect.DeepEqual(src, dst[2]) || dst[0] == dst[2] {
+			t.Fatalf("expected=%#v actual=%#v", src, dst)
+		}
+		if p := *dst[1].(*datastore.PropertyList); len(p) != 1 || p[0].Value != int64(3) {
+			t.Fatalf("expected=%#v actual=%#v", src, p)
+		}
+		if reads := Stats().DatastoreReads - before.DatastoreReads; reads != test.reads {
+			t.Fatalf("expected=%#v actual=%#v", test.reads, reads)
+		}
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPutMultiReturnsMemcacheError(t *testing.T) {
+	requireAetest(t)
+	src := Struct{I: 3}
+	key := datastore.NewIncompleteKey(c, "Struct", nil)
+	// Put with failing memcache delete
+	key, err := Put(failingContext("memcache", "Delete"), key, &src)
+	if err != errFailingContext {
+		t.Fatalf("expected=%#v actual=%#v", errFailingContext, err)
+	}
+	// datastore write still succeeded
+	dst := *new(Struct)
+	err = datastore.Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Put of an uncached entity isn't an error
+	key, err = Put(c, key, &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// countingCodec counts calls to Gob
+type countingCodec struct {
+	marshal, unmarshal int
+}
+
+func (c *countingCodec) Marshal(properties []datastore.Property) ([]byte, error) {
+	c.marshal++
+	return Gob.Marshal(properties)
+}
+
+func (c *countingCodec) Unmarshal(b []byte) ([]datastore.Property, error) {
+	c.unmarshal++
+	return Gob.Unmarshal(b)
+}
+
+func TestCodec(t *testing.T) {
+	requireAetest(t)
+	codec := &countingCodec{}
+	DefaultCodec = codec
+	defer func() { DefaultCodec = Gob }()
+	src := Struct{I: 3}
+	key := datastore.NewIncompleteKey(c, "Struct", nil)
+	// Put
+	key, err := Put(c, key, &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load memcache with Get
+	dst := *new(Struct)
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get from memcache
+	dst = *new(Struct)
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	if codec.marshal != 1 || codec.unmarshal != 1 {
+		t.Fatalf("expected=1 marshal, 1 unmarshal actual=%d marshal, %d unmarshal", codec.marshal, codec.unmarshal)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type LargeStruct struct {
+	B []byte
+}
+
+func TestLargeItemIsChunked(t *testing.T) {
+	requireAetest(t)
+	src := LargeStruct{B: make([]byte, 3*maxItemSize)}
+	for i := range src.B {
+		src.B[i] = byte(i)
+	}
+	key := datastore.NewKey(c, "LargeStruct", "large", 0, nil)
+	// cache without writing to datastore
+	err := defaultCachestore().cache([]*datastore.Key{key}, []LargeStruct{src}, nil, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := memcache.Get(c, defaultCachestore().encodeKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Flags&flagChunked == 0 {
+		t.Fatalf("expected chunked item actual flags=%#x", item.Flags)
+	}
+	// Get from memcache
+	dst := *new(LargeStruct)
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatal("expected large struct to round-trip through memcache")
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Get(c, key, &dst)
+	if err != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+}
+
+func TestKeyPrefix(t *testing.T) {
+	requireAetest(t)
+	src := Struct{I: 3}
+	key := datastore.NewIncompleteKey(c, "Struct", nil)
+	// Put
+	key, err := Put(c, key, &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load memcache with Get under a prefix
+	KeyPrefix = "prefix:"
+	defer func() { KeyPrefix = "" }()
+	dst := *new(Struct)
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = memcache.Get(c, "prefix:"+key.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = memcache.Get(c, key.Encode())
+	if err != memcache.ErrCacheMiss {
+		t.Fatalf("expected=%#v actual=%#v", memcache.ErrCacheMiss, err)
+	}
+	// Put evicts the prefixed key
+	key, err = Put(c, key, &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = memcache.Get(c, "prefix:"+key.Encode())
+	if err != memcache.ErrCacheMiss {
+		t.Fatalf("expected=%#v actual=%#v", memcache.ErrCacheMiss, err)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// countingLogger counts calls to Debugf
+type countingLogger struct {
+	n int64
+}
+
+func (l *countingLogger) Debugf(c context.Context, format string, args ...interface{}) {
+	atomic.AddInt64(&l.n, 1)
+}
+
+func TestSetLoggerConcurrently(t *testing.T) {
+	requireAetest(t)
+	defer SetLogger(nil)
+	src := Struct{I: 3}
+	key := datastore.NewIncompleteKey(c, "Struct", nil)
+	// Put
+	key, err := Put(c, key, &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get while toggling logging
+	l := &countingLogger{}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				dst := *new(Struct)
+				if err := Get(c, key, &dst); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			SetLogger(l)
+		} else {
+			SetLogger(nil)
+		}
+	}
+	wg.Wait()
+	// log with the logger set
+	SetLogger(l)
+	n := atomic.LoadInt64(&l.n)
+	dst := *new(Struct)
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt64(&l.n) == n {
+		t.Fatal("expected Get to log")
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDebugIsReadByNew(t *testing.T) {
+	defer func() { Debug = false }()
+	Debug = true
+	s := New()
+	Debug = false
+	// s keeps logging to AppEngineLogger, Cachestores created since don't
+	if s.Logger != AppEngineLogger {
+		t.Fatalf("expected=%#v actual=%#v", AppEngineLogger, s.Logger)
+	}
+	if l := New().Logger; l != nil {
+		t.Fatalf("expected=%#v actual=%#v", nil, l)
+	}
+}
+
+func TestGetMultiSharesConcurrentLoads(t *testing.T) {
+	requireAetest(t)
+	src := Struct{I: 3}
+	key, err := datastore.Put(c, datastore.NewIncompleteKey(c, "Struct", nil), &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// count slow datastore reads
+	var reads int64
+	ctx := appengine.WithAPICallFunc(c, func(ctx context.Context, service, method string, in, out proto.Message) error {
+		if service == "datastore_v3" && method == "Get" {
+			atomic.AddInt64(&reads, 1)
+			time.Sleep(100 * time.Millisecond)
+		}
+		return appengine.APICall(ctx, service, method, in, out)
+	})
+	// concurrent Gets of a cold key
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dst := *new(Struct)
+			if err := Get(ctx, key, &dst); err != nil {
+				t.Error(err)
+			} else if !reflect.DeepEqual(src, dst) {
+				t.Errorf("expected=%#v actual=%#v", src, dst)
+			}
+		}()
+	}
+	wg.Wait()
+	if reads != 1 {
+		t.Fatalf("expected=1 datastore read actual=%d", reads)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetMultiSharesConcurrentLoadErrors(t *testing.T) {
+	requireAetest(t)
+	key := datastore.NewKey(c, "Struct", "absent", 0, nil)
+	ctx := appengine.WithAPICallFunc(c, func(ctx context.Context, service, method string, in, out proto.Message) error {
+		if service == "datastore_v3" && method == "Get" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return appengine.APICall(ctx, service, method, in, out)
+	})
+	// concurrent Gets of an absent key
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dst := *new(Struct)
+			if err := Get(ctx, key, &dst); err != datastore.ErrNoSuchEntity {
+				t.Errorf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// reversedCodec is Gob with the bytes reversed, so that neither can decode the other's items.
+type reversedCodec struct{}
+
+func (reversedCodec) Marshal(properties []datastore.Property) ([]byte, error) {
+	b, err := Gob.Marshal(properties)
+	return reverse(b), err
+}
+
+func (reversedCodec) Unmarshal(b []byte) ([]datastore.Property, error) {
+	return Gob.Unmarshal(reverse(append([]byte(nil), b...)))
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+// blockingGetDatastore is a datastorer whose GetMulti counts its calls and waits for release.
+type blockingGetDatastore struct {
+	datastorer
+	reads   *int32
+	release chan struct{}
+}
+
+func (d blockingGetDatastore) GetMulti(c context.Context, key []*datastore.Key, dst interface{}) error {
+	atomic.AddInt32(d.reads, 1)
+	<-d.release
+	return d.datastorer.GetMulti(c, key, dst)
+}
+
+func TestGetMultiSharesLoadsAcrossConfigurations(t *testing.T) {
+	withFakeBackends(func(m *fakeMemcache, d *fakeDatastore) {
+		c := context.Background()
+		src := Widget{Name: "widget", Active: true}
+		key, err := Put(c, datastore.NewIncompleteKey(c, "Widget", nil), &src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reads int32
+		release := make(chan struct{})
+		datastoreBackend = blockingGetDatastore{d, &reads, release}
+		// a Get into a struct without Active leads the load, a Get with another Codec into a Widget follows it
+		lead, follow := New(), New()
+		follow.Codec = reversedCodec{}
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := lead.Get(c, key, &WidgetName{}); err == nil {
+				t.Errorf("expected=%#v actual=%#v", "ErrFieldMismatch", err)
+			}
+		}()
+		for atomic.LoadInt32(&reads) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		go func() {
+			defer wg.Done()
+			dst := Widget{}
+			if err := follow.Get(c, key, &dst); err != nil {
+				t.Error(err)
+			} else if !reflect.DeepEqual(src, dst) {
+				t.Errorf("expected=%#v actual=%#v", src, dst)
+			}
+		}()
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		if reads != 1 {
+			t.Fatalf("expected=1 datastore read actual=%d", reads)
+		}
+	})
+}
+
+func TestGetMultiFromMemcacheAllocatesStructPointers(t *testing.T) {
+	requireAetest(t)
+	src := *new([]*Struct)
+	key := *new([]*datastore.Key)
+	for i := 1; i < 11; i++ {
+		src = append(src, &Struct{I: i})
+		key = append(key, datastore.NewIncompleteKey(c, "Struct", nil))
+	}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load memcache with GetMulti
+	dst := make([]*Struct, len(src))
+	err = GetMulti(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti from memcache into nil pointers
+	dst = make([]*Struct, len(src))
+	err = GetMulti(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetMultiDoesNotCacheAbsentEntities(t *testing.T) {
+	requireAetest(t)
+	src := []*Struct{{I: 1}, {I: 3}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key = []*datastore.Key{key[0], datastore.NewKey(c, "Struct", "absent", 0, nil), key[1]}
+	// GetMulti from datastore into nil pointers
+	for _, reads := range []uint64{3, 1} {
+		before := Stats()
+		dst := make([]*Struct, len(key))
+		err = GetMulti(c, key, dst)
+		if me, ok := err.(appengine.MultiError); !ok || me[0] != nil || me[1] != datastore.ErrNoSuchEntity || me[2] != nil {
+			t.Fatalf("expected=%#v actual=%#v", appengine.MultiError{nil, datastore.ErrNoSuchEntity, nil}, err)
+		}
+		expected := []*Struct{src[0], nil, src[1]}
+		if !reflect.DeepEqual(expected, dst) {
+			t.Fatalf("expected=%#v actual=%#v", expected, dst)
+		}
+		if actual := Stats().DatastoreReads - before.DatastoreReads; actual != reads {
+			t.Fatalf("expected=%#v actual=%#v", reads, actual)
+		}
+	}
+	// only the entities that exist are cached
+	items, _, err := defaultCachestore().getItems(c, defaultCachestore().encodeKeys(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := items[defaultCachestore().encodeKey(key[1])]; ok || len(items) != 2 {
+		t.Fatalf("expected=%#v actual=%#v", 2, len(items))
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetMultiChecksDstLength(t *testing.T) {
+	key := make([]*datastore.Key, 10)
+	for i := range key {
+		key[i] = datastore.NewKey(c, "Struct", strconv.Itoa(i), 0, nil)
+	}
+	// too short
+	err := GetMulti(c, key, make([]Struct, 5))
+	if err != errMultiArgLength {
+		t.Fatalf("expected=%#v actual=%#v", errMultiArgLength, err)
+	}
+	// too long
+	err = GetMulti(c, key, make([]Struct, 15))
+	if err != errMultiArgLength {
+		t.Fatalf("expected=%#v actual=%#v", errMultiArgLength, err)
+	}
+	// not a slice
+	err = GetMulti(c, key, &Struct{})
+	if err != datastore.ErrInvalidEntityType {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrInvalidEntityType, err)
+	}
+}
+
+func TestPutMultiChecksSrcLength(t *testing.T) {
+	key := make([]*datastore.Key, 10)
+	for i := range key {
+		key[i] = datastore.NewIncompleteKey(c, "Struct", nil)
+	}
+	// too short
+	_, err := PutMulti(c, key, make([]Struct, 5))
+	if err != errMultiArgLength {
+		t.Fatalf("expected=%#v actual=%#v", errMultiArgLength, err)
+	}
+	// too long
+	_, err = PutMulti(c, key, make([]Struct, 15))
+	if err != errMultiArgLength {
+		t.Fatalf("expected=%#v actual=%#v", errMultiArgLength, err)
+	}
+	// not a slice
+	_, err = PutMulti(c, key, Struct{})
+	if err != datastore.ErrInvalidEntityType {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrInvalidEntityType, err)
+	}
+}
+
+func TestPutMultiBatches(t *testing.T) {
+	requireAetest(t)
+	src := make([]Struct, 1200)
+	key := make([]*datastore.Key, len(src))
+	for i := range src {
+		src[i] = Struct{I: i}
+		key[i] = datastore.NewKey(c, "Struct", strconv.Itoa(i), 0, nil)
+	}
+	// PutMulti and GetMulti to cache
+	_, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(key); i += 600 {
+		err = GetMulti(c, key[i:i+600], make([]Struct, 600))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// PutMulti with incomplete keys
+	var puts int64
+	ctx := appengine.WithAPICallFunc(c, func(ctx context.Context, service, method string, in, out proto.Message) error {
+		if service == "datastore_v3" && method == "Put" {
+			atomic.AddInt64(&puts, 1)
+		}
+		return appengine.APICall(ctx, service, method, in, out)
+	})
+	putKey := make([]*datastore.Key, len(key))
+	copy(putKey, key)
+	for i := 0; i < len(putKey); i += 100 {
+		putKey[i] = datastore.NewIncompleteKey(c, "Struct", nil)
+	}
+	for i := range src {
+		src[i].I *= 2
+	}
+	putKey, err = PutMulti(ctx, putKey, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if puts != 3 {
+		t.Fatalf("expected=%#v actual=%#v", 3, puts)
+	}
+	for i, k := range putKey {
+		if i%100 == 0 {
+			if k.Incomplete() {
+				t.Fatalf("expected a complete key at %d", i)
+			}
+		} else if !k.Equal(key[i]) {
+			t.Fatalf("expected=%#v actual=%#v", key[i], k)
+		}
+	}
+	// GetMulti from datastore
+	items, _, err := defaultCachestore().getItems(c, defaultCachestore().encodeKeys(putKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, len(items))
+	}
+	dst := make([]Struct, len(putKey))
+	for i := 0; i < len(putKey); i += 600 {
+		err = GetMulti(c, putKey[i:i+600], dst[i:i+600])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, append(putKey, key...))
+	if err != nil {
+		if _, ok := err.(appengine.MultiError); !ok {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWriteThrough(t *testing.T) {
+	requireAetest(t)
+	WriteThrough = true
+	defer func() { WriteThrough = false }()
+	src := &Struct{I: 3}
+	// Put
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get from memcache
+	before := Stats()
+	dst := &Struct{}
+	err = Get(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	if reads := Stats().DatastoreReads - before.DatastoreReads; reads != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, reads)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemcacheTimeoutFallsBackToDatastore(t *testing.T) {
+	requireAetest(t)
+	MemcacheTimeout = 10 * time.Millisecond
+	defer func() { MemcacheTimeout = 0 }()
+	src := &Struct{I: 4}
+	// Put
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get with slow memcache
+	slow := appengine.WithAPICallFunc(c, func(ctx context.Context, s, m string, in, out proto.Message) error {
+		if s == "memcache" && m == "Get" {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+		}
+		return appengine.APICall(ctx, s, m, in, out)
+	})
+	start := time.Now()
+	dst := &Struct{}
+	err = Get(slow, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Fatalf("expected Get to time out memcache, took %v", elapsed)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeItemsTreatsNilItemsAsMissing(t *testing.T) {
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{
+		datastore.NewKey(c, "Struct", "", 1, nil),
+		datastore.NewKey(c, "Struct", "", 2, nil),
+	}
+	value, err := defaultCachestore().encode(&src[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// inconsistent items: the first is present but nil
+	items := map[string]*memcache.Item{
+		defaultCachestore().encodeKey(key[0]): nil,
+		defaultCachestore().encodeKey(key[1]): {Key: defaultCachestore().encodeKey(key[1]), Value: value},
+	}
+	dst := make([]Struct, len(key))
+	missing, errs := defaultCachestore().decodeItems(key, items, dst)
+	if !reflect.DeepEqual(missing, []int{0}) {
+		t.Fatalf("expected=%#v actual=%#v", []int{0}, missing)
+	}
+	if errs[0] != errItemMissing || errs[1] != nil {
+		t.Fatalf("expected=%#v actual=%#v", appengine.MultiError{errItemMissing, nil}, errs)
+	}
+	if dst[1] != src[1] {
+		t.Fatalf("expected=%#v actual=%#v", src[1], dst[1])
+	}
+}
+
+// cacheCorrupt caches a value that can't be decoded for key.
+func cacheCorrupt(t *testing.T, key *datastore.Key) {
+	generation, err := defaultCachestore().getGeneration(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := defaultCachestore().newItem(defaultCachestore().encodeKey(key), []byte("corrupt"))
+	err = defaultCachestore().setItems(c, []*memcache.Item{item}, generation)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetFallsBackToDatastoreWhenCacheDecodeFails(t *testing.T) {
+	requireAetest(t)
+	src := &Struct{I: 7}
+	// Put
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheCorrupt(t, key)
+	// GetMemcacheOnly
+	err = GetMemcacheOnly(c, key, &Struct{})
+	if e, ok := err.(*ErrCacheDecode); !ok || !e.Key.Equal(key) {
+		t.Fatalf("expected=%#v actual=%#v", &ErrCacheDecode{Key: key}, err)
+	}
+	// Get from datastore
+	dst := &Struct{}
+	err = Get(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetMultiRepairsCorruptItems(t *testing.T) {
+	requireAetest(t)
+	src := []Struct{{1}, {2}, {3}}
+	key := make([]*datastore.Key, len(src))
+	for i := range key {
+		key[i] = datastore.NewIncompleteKey(c, "Struct", nil)
+	}
+	// PutMulti and GetMulti to cache
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = GetMulti(c, key, make([]Struct, len(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti with a corrupt item
+	cacheCorrupt(t, key[1])
+	dst := make([]Struct, len(key))
+	err = GetMulti(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// repaired
+	dst = make([]Struct, len(key))
+	err = GetMultiMemcacheOnly(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// GetMulti with a corrupt item and a ReadOnly context removes it
+	cacheCorrupt(t, key[1])
+	err = GetMulti(ReadOnly(c), key, make([]Struct, len(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = GetMemcacheOnly(c, key[1], &Struct{})
+	if err != memcache.ErrCacheMiss {
+		t.Fatalf("expected=%#v actual=%#v", memcache.ErrCacheMiss, err)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetMultiDoesNotCacheValuesReplacedDuringRead(t *testing.T) {
+	requireAetest(t)
+	src := &Struct{I: 1}
+	// Put
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get, with a Put between its datastore read and its memcache write
+	updated := &Struct{I: 2}
+	interleaved := appengine.WithAPICallFunc(c, func(ctx context.Context, s, m string, in, out proto.Message) error {
+		err := appengine.APICall(ctx, s, m, in, out)
+		if s == "datastore_v3" && m == "Get" {
+			if _, err := Put(c, key, updated); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return err
+	})
+	dst := &Struct{}
+	err = Get(interleaved, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// Get the value written by the Put
+	dst = &Struct{}
+	err = Get(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(updated, dst) {
+		t.Fatalf("expected=%#v actual=%#v", updated, dst)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemcacheBatches(t *testing.T) {
+	requireAetest(t)
+	MemcacheBatchSize = 10
+	defer func() { MemcacheBatchSize = 1000 }()
+	src := *new([]Struct)
+	key := *new([]*datastore.Key)
+	for i := 1; i < 26; i++ {
+		src = append(src, Struct{I: i})
+		key = append(key, datastore.NewIncompleteKey(c, "Struct", nil))
+	}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load memcache with GetMulti
+	dst := make([]Struct, len(src))
+	err = GetMulti(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti from memcache in batches of 10 keys: 25 keys and the generation
+	var calls int32
+	counting := appengine.WithAPICallFunc(c, func(ctx context.Context, s, m string, in, out proto.Message) error {
+		if s == "memcache" && m == "Get" {
+			atomic.AddInt32(&calls, 1)
+		}
+		return appengine.APICall(ctx, s, m, in, out)
+	})
+	before := Stats()
+	dst = make([]Struct, len(src))
+	err = GetMulti(counting, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	if calls != 3 {
+		t.Fatalf("expected=%#v actual=%#v", 3, calls)
+	}
+	if hits := Stats().Hits - before.Hits; hits != uint64(len(key)) {
+		t.Fatalf("expected=%#v actual=%#v", len(key), hits)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, _, err := defaultCachestore().getItems(c, defaultCachestore().encodeKeys(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, len(items))
+	}
+}
+
+func TestBatchMergesMultiErrors(t *testing.T) {
+	MemcacheBatchSize = 10
+	defer func() { MemcacheBatchSize = 1000 }()
+	var ranges [][2]int
+	err := defaultCachestore().batch(25, func(i, j int) error {
+		ranges = append(ranges, [2]int{i, j})
+		me := make(appengine.MultiError, j-i)
+		me[0] = memcache.ErrNotStored
+		return me
+	})
+	expectedRanges := [][2]int{{0, 10}, {10, 20}, {20, 25}}
+	if !reflect.DeepEqual(expectedRanges, ranges) {
+		t.Fatalf("expected=%#v actual=%#v", expectedRanges, ranges)
+	}
+	me, ok := err.(appengine.MultiError)
+	if !ok || len(me) != 25 {
+		t.Fatalf("expected a MultiError of 25 errors, actual=%#v", err)
+	}
+	for i, e := range me {
+		if (i%10 == 0) != (e == memcache.ErrNotStored) {
+			t.Fatalf("unexpected error %d: %#v", i, e)
+		}
+	}
+}
+
+func TestGetMultiReturnsEntitiesThatFailToCache(t *testing.T) {
+	requireAetest(t)
+	MemcacheBatchSize = 1
+	defer func() { MemcacheBatchSize = 1000 }()
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti, failing to cache the second key: memcache sets are a lock for each key, then a swap for each key
+	var sets int32
+	failing := appengine.WithAPICallFunc(c, func(ctx context.Context, s, m string, in, out proto.Message) error {
+		if s == "memcache" && m == "Set" && atomic.AddInt32(&sets, 1) == 4 {
+			return appengine.MultiError{memcache.ErrServerError}
+		}
+		return appengine.APICall(ctx, s, m, in, out)
+	})
+	before := Stats()
+	dst := make([]Struct, len(src))
+	err = GetMulti(failing, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	if cacheErrors := Stats().CacheErrors - before.CacheErrors; cacheErrors != 1 {
+		t.Fatalf("expected=%#v actual=%#v", 1, cacheErrors)
+	}
+	items, _, err := defaultCachestore().getItems(c, defaultCachestore().encodeKeys(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items[defaultCachestore().encodeKey(key[0])] == nil || items[defaultCachestore().encodeKey(key[1])] != nil {
+		t.Fatalf("expected only the first key to be cached, actual=%#v", items)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCachestoresWithDifferentPrefixes(t *testing.T) {
+	requireAetest(t)
+	a, b := &Cachestore{KeyPrefix: "a:"}, &Cachestore{KeyPrefix: "b:"}
+	src := &Struct{I: 6}
+	// Put
+	key, err := a.Put(c, datastore.NewIncompleteKey(c, "Struct", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get with both, caching with both
+	for _, s := range []*Cachestore{a, b} {
+		dst := &Struct{}
+		err = s.Get(c, key, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src, dst) {
+			t.Fatalf("expected=%#v actual=%#v", src, dst)
+		}
+	}
+	// Flush a without flushing b
+	err = a.Flush(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, _, err := a.getItems(c, []string{a.encodeKey(key)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, len(items))
+	}
+	items, _, err = b.getItems(c, []string{b.encodeKey(key)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items[b.encodeKey(key)] == nil {
+		t.Fatalf("expected b's cached item to survive flushing a")
+	}
+	// Delete
+	err = b.Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncompleteKeys(t *testing.T) {
+	requireAetest(t)
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), &Struct{I: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	incomplete := datastore.NewIncompleteKey(c, "Struct", nil)
+	// Get without hitting memcache or datastore
+	var calls int32
+	counting := appengine.WithAPICallFunc(c, func(ctx context.Context, s, m string, in, out proto.Message) error {
+		atomic.AddInt32(&calls, 1)
+		return appengine.APICall(ctx, s, m, in, out)
+	})
+	err = Get(counting, incomplete, &Struct{})
+	if err != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, calls)
+	}
+	// GetMulti
+	dst := make([]Struct, 2)
+	err = GetMulti(c, []*datastore.Key{incomplete, key}, dst)
+	expected := appengine.MultiError{datastore.ErrNoSuchEntity, nil}
+	if !reflect.DeepEqual(expected, err) {
+		t.Fatalf("expected=%#v actual=%#v", expected, err)
+	}
+	if dst[1].I != 7 {
+		t.Fatalf("expected=%#v actual=%#v", 7, dst[1].I)
+	}
+	// Delete
+	err = Delete(c, incomplete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, []*datastore.Key{incomplete, key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Get(c, key, &Struct{})
+	if err != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+}
+
+func TestGetMultiWhenMemcacheIsDown(t *testing.T) {
+	requireAetest(t)
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti with memcache failing
+	dst := make([]Struct, len(src))
+	err = GetMulti(failingContext("memcache", "Get"), key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetMultiWhenDatastoreIsDown(t *testing.T) {
+	requireAetest(t)
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cache the first
+	err = Get(c, key[0], &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti with datastore failing loads the first from memcache
+	dst := make([]Struct, len(src))
+	err = GetMulti(failingContext("datastore_v3", "Get"), key, dst)
+	me, ok := err.(appengine.MultiError)
+	if !ok || me[0] != nil || me[1] == nil {
+		t.Fatalf("expected=%#v actual=%#v", appengine.MultiError{nil, errFailingContext}, err)
+	}
+	if dst[0] != src[0] {
+		t.Fatalf("expected=%#v actual=%#v", src[0], dst[0])
+	}
+	// Get of the missing one fails with datastore's error
+	err = Get(failingContext("datastore_v3", "Get"), key[1], &Struct{})
+	if _, ok := err.(appengine.MultiError); err == nil || ok {
+		t.Fatalf("expected=%#v actual=%#v", errFailingContext, err)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// slowGetMemcache is a MemcacheBackend whose GetMulti only returns once its context is done.
+type slowGetMemcache struct {
+	MemcacheBackend
+}
+
+func (m slowGetMemcache) GetMulti(c context.Context, key []string) (map[string]*memcache.Item, error) {
+	items, err := m.MemcacheBackend.GetMulti(c, key)
+	<-c.Done()
+	return items, err
+}
+
+func TestGetMultiSkipsDatastoreAfterDeadline(t *testing.T) {
+	requireAetest(t)
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cache the first
+	err = Get(c, key[0], &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti with a deadline that passes while reading memcache
+	defer func(m MemcacheBackend) { memcacheBackend = m }(memcacheBackend)
+	memcacheBackend = slowGetMemcache{memcacheBackend}
+	tc, cancel := context.WithTimeout(c, 10*time.Millisecond)
+	defer cancel()
+	before := Stats()
+	dst := make([]Struct, len(src))
+	err = GetMulti(tc, key, dst)
+	if me, ok := err.(appengine.MultiError); !ok || me[0] != nil || me[1] != context.DeadlineExceeded {
+		t.Fatalf("expected=%#v actual=%#v", appengine.MultiError{nil, context.DeadlineExceeded}, err)
+	}
+	if dst[0] != src[0] {
+		t.Fatalf("expected=%#v actual=%#v", src[0], dst[0])
+	}
+	if reads := Stats().DatastoreReads - before.DatastoreReads; reads != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, reads)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// erringGetMemcache is a MemcacheBackend whose GetMulti returns err along with the items it found.
+type erringGetMemcache struct {
+	MemcacheBackend
+	err error
+}
+
+func (m erringGetMemcache) GetMulti(c context.Context, key []string) (map[string]*memcache.Item, error) {
+	items, _ := m.MemcacheBackend.GetMulti(c, key)
+	return items, m.err
+}
+
+func TestGetMultiWhenMemcacheReturnsAnError(t *testing.T) {
+	requireAetest(t)
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load memcache with GetMulti
+	err = GetMulti(c, key, make([]Struct, len(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// update datastore without cachestore
+	changed := []Struct{{3}, {4}}
+	_, err = datastore.PutMulti(c, key, changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		err      error
+		expected []Struct
+		reads    uint64
+	}{
+		{memcache.ErrCacheMiss, src, 0},             // the items found are cached
+		{errors.New("memcache failed"), changed, 2}, // the items found may be partial
+	}
+	defer func(m MemcacheBackend) { memcacheBackend = m }(memcacheBackend)
+	for _, test := range tests {
+		memcacheBackend = erringGetMemcache{appengineMemcache{}, test.err}
+		// GetMulti
+		before := Stats()
+		dst := make([]Struct, len(key))
+		err = GetMulti(c, key, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(test.expected, dst) {
+			t.Fatalf("expected=%#v actual=%#v", test.expected, dst)
+		}
+		if reads := Stats().DatastoreReads - before.DatastoreReads; reads != test.reads {
+			t.Fatalf("expected=%#v actual=%#v", test.reads, reads)
+		}
+	}
+	memcacheBackend = appengineMemcache{}
+	// GetMulti from memcache, which still has the items
+	before := Stats()
+	dst := make([]Struct, len(key))
+	err = GetMulti(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	if reads := Stats().DatastoreReads - before.DatastoreReads; reads != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, reads)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetAndDelete(t *testing.T) {
+	requireAetest(t)
+	src := &Struct{I: 8}
+	// Put
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetAndDelete
+	dst := &Struct{}
+	err = GetAndDelete(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	err = Get(c, key, &Struct{})
+	if err != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+	// GetAndDelete again
+	err = GetAndDelete(c, key, &Struct{})
+	if err != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+}
+
+func TestGetAndDeleteDoesNotDeleteWhenGetFails(t *testing.T) {
+	requireAetest(t)
+	// Put
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), &Struct{I: 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetAndDelete into a struct without the I field
+	err = GetAndDelete(c, key, &LargeStruct{})
+	if _, ok := err.(*datastore.ErrFieldMismatch); !ok {
+		t.Fatalf("expected=%#v actual=%#v", &datastore.ErrFieldMismatch{}, err)
+	}
+	err = Get(c, key, &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	requireAetest(t)
+	src := &Struct{I: 10}
+	// Put
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load memcache with Get
+	err = Get(c, key, &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get with a new version from datastore, then from memcache
+	Version = 1
+	defer func() { Version = 0 }()
+	for _, reads := range []uint64{1, 0} {
+		before := Stats()
+		dst := &Struct{}
+		err = Get(c, key, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src, dst) {
+			t.Fatalf("expected=%#v actual=%#v", src, dst)
+		}
+		if actual := Stats().DatastoreReads - before.DatastoreReads; actual != reads {
+			t.Fatalf("expected=%#v actual=%#v", reads, actual)
+		}
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestItemFlags(t *testing.T) {
+	requireAetest(t)
+	ItemFlags, Version = 0xab01, 2
+	defer func() { ItemFlags, Version = 0, 0 }()
+	src := &Struct{I: 11}
+	// Put
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get from datastore, then from memcache
+	for _, reads := range []uint64{1, 0} {
+		before := Stats()
+		dst := &Struct{}
+		err = Get(c, key, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src, dst) {
+			t.Fatalf("expected=%#v actual=%#v", src, dst)
+		}
+		if actual := Stats().DatastoreReads - before.DatastoreReads; actual != reads {
+			t.Fatalf("expected=%#v actual=%#v", reads, actual)
+		}
+	}
+	// the item has ItemFlags without the bit cachestore uses, and Version
+	item, err := memcache.Get(c, defaultCachestore().encodeKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := uint32(2<<versionShift | 0xab00); item.Flags != expected {
+		t.Fatalf("expected=%#x actual=%#x", expected, item.Flags)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetMultiErrors(t *testing.T) {
+	requireAetest(t)
+	mismatch := &datastore.ErrFieldMismatch{StructType: reflect.TypeOf(WidgetName{}), FieldName: "Active", Reason: "no such struct field"}
+	tests := []struct {
+		cached string      // "", "corrupt" or "cached"
+		src    interface{} // saved to datastore, unless nil
+		err    error
+	}{
+		{"", nil, datastore.ErrNoSuchEntity},
+		{"", &WidgetName{Name: "a"}, nil},
+		{"", &Widget{Name: "b"}, mismatch},
+		{"corrupt", nil, datastore.ErrNoSuchEntity},
+		{"corrupt", &WidgetName{Name: "c"}, nil},
+		{"corrupt", &Widget{Name: "d"}, mismatch},
+		{"cached", &WidgetName{Name: "e"}, nil},
+		{"cached", &Widget{Name: "f"}, mismatch},
+	}
+	key := make([]*datastore.Key, len(tests))
+	expected := make(appengine.MultiError, len(tests))
+	for i, test := range tests {
+		key[i] = datastore.NewKey(c, "Widget", fmt.Sprint("errors", i), 0, nil)
+		expected[i] = test.err
+		if test.src != nil {
+			_, err := datastore.Put(c, key[i], test.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		switch test.cached {
+		case "corrupt":
+			cacheCorrupt(t, key[i])
+		case "cached":
+			dst := reflect.New(reflect.TypeOf(test.src).Elem()).Interface()
+			err := Get(c, key[i], dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// GetMulti into structs without Active, reading the keys that weren't cached from datastore
+	before := Stats()
+	dst := make([]*WidgetName, len(key))
+	err := GetMulti(c, key, dst)
+	if !reflect.DeepEqual(expected, err) {
+		t.Fatalf("expected=%#v actual=%#v", expected, err)
+	}
+	if reads := Stats().DatastoreReads - before.DatastoreReads; reads != 6 {
+		t.Fatalf("expected=%#v actual=%#v", 6, reads)
+	}
+	for i, test := range tests {
+		if test.src != nil && dst[i].Name != reflect.ValueOf(test.src).Elem().FieldByName("Name").String() {
+			t.Fatalf("%d: expected=%#v actual=%#v", i, test.src, dst[i])
+		}
+	}
+	// Delete
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
 	}
 }
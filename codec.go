@@ -0,0 +1,126 @@
+package cachestore
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// Codec marshals and unmarshals the properties of cached entities to and from memcache item values.
+type Codec interface {
+	Marshal([]datastore.Property) ([]byte, error)
+	Unmarshal([]byte) ([]datastore.Property, error)
+}
+
+// Gob is a Codec that uses the gob package. Property values that aren't basic datastore types need to be
+// registered with Register or gob.Register(interface{}).
+var Gob Codec = gobCodec{}
+
+func init() {
+	// register basic datastore types
+	Register(time.Time{}, datastore.Key{}, appengine.BlobKey(""), appengine.GeoPoint{})
+}
+
+// registered holds the types registered by Register, in the order they were first registered.
+var registered struct {
+	mu    sync.Mutex
+	types []reflect.Type
+	seen  map[reflect.Type]bool
+}
+
+// Register registers the types of values with gob, so that Gob can encode property values of those types, and records
+// them for RegisteredTypes. Like gob.Register it panics if a type was registered under another name. gob registers a
+// type and pointers to it together, so register either T{} or &T{}, not both: values are decoded as the type that was
+// registered. Register every type on every instance, since an instance can only decode the types it registered.
+func Register(values ...interface{}) {
+	registered.mu.Lock()
+	defer registered.mu.Unlock()
+	for _, v := range values {
+		gob.Register(v)
+		t := reflect.TypeOf(v)
+		if !registered.seen[t] {
+			if registered.seen == nil {
+				registered.seen = map[reflect.Type]bool{}
+			}
+			registered.seen[t] = true
+			registered.types = append(registered.types, t)
+		}
+	}
+}
+
+// RegisteredTypes returns the types registered by Register, including the basic datastore types cachestore registers
+// itself, for example to check at startup that the types of cached property values are registered. Types registered
+// with gob.Register directly aren't included.
+func RegisteredTypes() []reflect.Type {
+	registered.mu.Lock()
+	defer registered.mu.Unlock()
+	return append([]reflect.Type(nil), registered.types...)
+}
+
+type gobCodec struct{}
+
+// gobBuffers holds the buffers gobCodec.Marshal encodes into, so that encoding many entities doesn't grow a new one
+// for each.
+var gobBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+func (gobCodec) Marshal(properties []datastore.Property) ([]byte, error) {
+	properties = withoutNilKeys(properties)
+	buffer := gobBuffers.Get().(*bytes.Buffer)
+	defer gobBuffers.Put(buffer)
+	buffer.Reset()
+	encoder := gob.NewEncoder(buffer)
+	if err := encoder.Encode(properties); err != nil {
+		return nil, gobPropertyError(properties, err)
+	}
+	return append([]byte(nil), buffer.Bytes()...), nil
+}
+
+// withoutNilKeys returns properties with nil key pointers replaced by nil values, like datastore loads them, since gob
+// can't encode nil pointers in interfaces. properties is only copied if it has nil keys.
+func withoutNilKeys(properties []datastore.Property) []datastore.Property {
+	copied := false
+	for i, p := range properties {
+		if key, ok := p.Value.(*datastore.Key); ok && key == nil {
+			if !copied {
+				properties, copied = append([]datastore.Property(nil), properties...), true
+			}
+			properties[i].Value = nil
+		}
+	}
+	return properties
+}
+
+// gobPropertyError returns err, which occurred gob encoding properties, naming the property that can't be encoded.
+// Types aren't registered automatically because instances that haven't encoded them couldn't decode them.
+func gobPropertyError(properties []datastore.Property, err error) error {
+	for _, p := range properties {
+		if e := gob.NewEncoder(ioutil.Discard).Encode([]datastore.Property{p}); e != nil {
+			return fmt.Errorf("cachestore: gob encoding property %q of type %T (it may need to be registered with gob.Register): %v", p.Name, p.Value, e)
+		}
+	}
+	return err
+}
+
+func (gobCodec) Unmarshal(b []byte) ([]datastore.Property, error) {
+	var properties []datastore.Property
+	reader := bytes.NewReader(b)
+	decoder := gob.NewDecoder(reader)
+	if err := decoder.Decode(&properties); err != nil {
+		return nil, err
+	}
+	for i, p := range properties {
+		// gob encoded key pointers as keys, convert them back to pointers, which are the only keys properties hold. The
+		// values of a []*datastore.Key are separate properties with the same name, so each is converted.
+		if key, ok := p.Value.(datastore.Key); ok {
+			properties[i].Value = &key
+		}
+	}
+	return properties, nil
+}
@@ -0,0 +1,220 @@
+package cachestore
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+type unregistered struct {
+	S string
+}
+
+func TestGobNamesUnregisteredProperty(t *testing.T) {
+	properties := []datastore.Property{
+		{Name: "I", Value: int64(1)},
+		{Name: "U", Value: unregistered{"u"}},
+	}
+	_, err := Gob.Marshal(properties)
+	if err == nil {
+		t.Fatal("expected an error encoding an unregistered type")
+	}
+	for _, s := range []string{`"U"`, "cachestore.unregistered", "gob.Register"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("expected=%#v actual=%#v", s, err.Error())
+		}
+	}
+}
+
+type laterRegistered struct {
+	S string
+}
+
+func TestRegister(t *testing.T) {
+	properties := []datastore.Property{{Name: "R", Value: laterRegistered{"r"}}}
+	_, err := Gob.Marshal(properties)
+	if err == nil {
+		t.Fatal("expected an error encoding an unregistered type")
+	}
+	// Register twice
+	Register(laterRegistered{})
+	Register(laterRegistered{})
+	b, err := Gob.Marshal(properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Gob.Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(properties, decoded) {
+		t.Fatalf("expected=%#v actual=%#v", properties, decoded)
+	}
+	// RegisteredTypes lists each once, with the basic datastore types
+	count := map[reflect.Type]int{}
+	for _, typ := range RegisteredTypes() {
+		count[typ]++
+	}
+	for _, v := range []interface{}{laterRegistered{}, time.Time{}, datastore.Key{}} {
+		if n := count[reflect.TypeOf(v)]; n != 1 {
+			t.Fatalf("%T: expected=%#v actual=%#v", v, 1, n)
+		}
+	}
+}
+
+type KeyStruct struct {
+	K    *datastore.Key
+	Nil  *datastore.Key
+	Keys []*datastore.Key
+}
+
+func TestGobKeys(t *testing.T) {
+	requireAetest(t)
+	parent := datastore.NewKey(c, "Parent", "parent", 0, nil)
+	src := &KeyStruct{K: parent, Keys: []*datastore.Key{parent, datastore.NewKey(c, "Child", "", 2, parent), nil}}
+	// Put
+	key, err := Put(c, datastore.NewIncompleteKey(c, "KeyStruct", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get from datastore, then from memcache
+	for _, reads := range []uint64{1, 0} {
+		before := Stats()
+		dst := &KeyStruct{}
+		err = Get(c, key, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src, dst) {
+			t.Fatalf("expected=%#v actual=%#v", src, dst)
+		}
+		if actual := Stats().DatastoreReads - before.DatastoreReads; actual != reads {
+			t.Fatalf("expected=%#v actual=%#v", reads, actual)
+		}
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGobRepeatedKeyProperties(t *testing.T) {
+	parent := datastore.NewKey(c, "Parent", "parent", 0, nil)
+	child := datastore.NewKey(c, "Child", "", 2, parent)
+	properties, err := datastore.SaveStruct(&KeyStruct{Keys: []*datastore.Key{parent, child}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Gob.Marshal(properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Gob.Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := *new([]*datastore.Key)
+	for _, p := range decoded {
+		if p.Name != "Keys" {
+			continue
+		}
+		key, ok := p.Value.(*datastore.Key)
+		if !ok || !p.Multiple {
+			t.Fatalf("expected a multiple *datastore.Key, actual=%#v", p)
+		}
+		keys = append(keys, key)
+	}
+	if expected := []*datastore.Key{parent, child}; !reflect.DeepEqual(expected, keys) {
+		t.Fatalf("expected=%#v actual=%#v", expected, keys)
+	}
+}
+
+type TimeStruct struct {
+	T time.Time
+}
+
+func TestTimesLoadLikeDatastore(t *testing.T) {
+	requireAetest(t)
+	WriteThrough = true
+	defer func() { WriteThrough = false }()
+	local := time.Date(2015, 6, 7, 8, 9, 10, 123456789, time.FixedZone("UTC+2", 2*60*60))
+	expected := time.Date(2015, 6, 7, 6, 9, 10, 123456000, time.UTC)
+	// Put a struct and a PropertyList
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "TimeStruct", nil), datastore.NewIncompleteKey(c, "TimeStruct", nil)}
+	src := []interface{}{&TimeStruct{T: local}, &datastore.PropertyList{{Name: "T", Value: local}}}
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get from memcache
+	dst := []TimeStruct{{}, {}}
+	err = GetMulti(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range dst {
+		if d.T != expected {
+			t.Fatalf("expected=%#v actual=%#v", expected, d.T)
+		}
+	}
+	// Get the struct from datastore
+	err = Get(Strong(c), key[0], &dst[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst[0].T != expected {
+		t.Fatalf("expected=%#v actual=%#v", expected, dst[0].T)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkEncodeItems(b *testing.B) {
+	s := New()
+	src := make([]Struct, 500)
+	key := make([]*datastore.Key, len(src))
+	for i := range key {
+		src[i] = Struct{I: i}
+		key[i] = datastore.NewKey(c, "Struct", "", int64(i+1), nil)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.encodeItems(key, src, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeItems(b *testing.B) {
+	s := New()
+	src := make([]Struct, 500)
+	key := make([]*datastore.Key, len(src))
+	for i := range key {
+		src[i] = Struct{I: i}
+		key[i] = datastore.NewKey(c, "Struct", "", int64(i+1), nil)
+	}
+	items, err := s.encodeItems(key, src, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	itemMap := make(map[string]*memcache.Item, len(items))
+	for _, item := range items {
+		itemMap[item.Key] = item
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, errs := s.decodeItems(key, itemMap, make([]Struct, len(key))); errs[0] != nil {
+			b.Fatal(errs[0])
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package cachestore
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+)
+
+// shouldCompress returns whether to compress an encoded entity of size bytes: CompressIf decides if it's set,
+// otherwise Compress and CompressMinSize.
+func (s *Cachestore) shouldCompress(size int) bool {
+	if s.CompressIf != nil {
+		return s.CompressIf(size)
+	}
+	return s.Compress && size >= s.CompressMinSize
+}
+
+// compress compresses value using flate.
+func compress(value []byte) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	writer, err := flate.NewWriter(buffer, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(value); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// decompress decompresses a value compressed by compress.
+func decompress(value []byte) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(value))
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
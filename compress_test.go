@@ -0,0 +1,176 @@
+package cachestore
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+type TextStruct struct {
+	S string
+}
+
+func TestCompress(t *testing.T) {
+	requireAetest(t)
+	Compress = true
+	defer func() { Compress = false }()
+	for _, src := range []TextStruct{{S: strings.Repeat("compressible ", 1000)}, {S: "small"}} {
+		key, err := Put(c, datastore.NewIncompleteKey(c, "TextStruct", nil), &src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// load memcache with Get
+		dst := *new(TextStruct)
+		err = Get(c, key, &dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// only large values are compressed
+		item, err := memcache.Get(c, defaultCachestore().encodeKey(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed := len(src.S) >= CompressMinSize
+		if (item.Flags&flagCompressed != 0) != compressed {
+			t.Fatalf("len=%d expected compressed=%v actual flags=%#x", len(src.S), compressed, item.Flags)
+		}
+		if compressed && len(item.Value) >= len(src.S) {
+			t.Fatalf("expected < %d bytes actual=%d", len(src.S), len(item.Value))
+		}
+		// Get from memcache
+		dst = *new(TextStruct)
+		err = Get(c, key, &dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src, dst) {
+			t.Fatalf("expected=%#v actual=%#v", src, dst)
+		}
+		// Delete
+		err = Delete(c, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCompressReadsUncompressedItems(t *testing.T) {
+	requireAetest(t)
+	src := TextStruct{S: strings.Repeat("compressible ", 1000)}
+	key, err := Put(c, datastore.NewIncompleteKey(c, "TextStruct", nil), &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load memcache with Get before enabling compression
+	dst := *new(TextStruct)
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Compress = true
+	defer func() { Compress = false }()
+	// remove from datastore
+	err = datastore.Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get from memcache
+	dst = *new(TextStruct)
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompressWithLocalCache(t *testing.T) {
+	requireAetest(t)
+	Compress, LocalCache = true, NewLRU(10)
+	defer func() { Compress, LocalCache = false, nil }()
+	src := TextStruct{S: strings.Repeat("compressible ", 1000)}
+	key, err := Put(c, datastore.NewIncompleteKey(c, "TextStruct", nil), &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get from datastore, caching in memcache and the local cache
+	dst := *new(TextStruct)
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// remove from datastore
+	err = datastore.Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get from the local cache
+	dst = *new(TextStruct)
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompressIf(t *testing.T) {
+	requireAetest(t)
+	CompressIf = func(size int) bool { return size >= 1024 }
+	defer func() { CompressIf = nil }()
+	large := strings.Repeat("compressible ", 1000)
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "PropertyLoadSaver", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	src := []interface{}{&PropertyLoadSaver{S: large}, &Struct{I: 1}}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load memcache with GetMulti
+	err = GetMulti(c, key, []interface{}{&PropertyLoadSaver{}, &Struct{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the large PropertyLoadSaver is compressed
+	for i, compressed := range []bool{true, false} {
+		item, err := memcache.Get(c, defaultCachestore().encodeKey(key[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (item.Flags&flagCompressed != 0) != compressed {
+			t.Fatalf("expected compressed=%v actual flags=%#x", compressed, item.Flags)
+		}
+	}
+	// GetMulti from memcache
+	pls, s := &PropertyLoadSaver{}, &Struct{}
+	err = GetMulti(c, key, []interface{}{pls, s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(pls.S, large) {
+		t.Fatalf("expected=%#v actual=%#v", large, pls.S)
+	}
+	if !reflect.DeepEqual(src[1], s) {
+		t.Fatalf("expected=%#v actual=%#v", src[1], s)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,29 @@
+package cachestore
+
+import (
+	"context"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// DeleteIfExists deletes the entities for key using the default Cachestore, returning which existed. See
+// Cachestore.DeleteIfExists.
+func DeleteIfExists(c context.Context, key []*datastore.Key) ([]bool, error) {
+	return defaultCachestore().DeleteIfExists(c, key)
+}
+
+// DeleteIfExists is DeleteMulti, also returning whether each key had an entity before it was deleted, for example to
+// report how many entities a deletion removed. Whether they existed is checked first with ExistsMulti, so an entity
+// written between the check and the deletion is deleted but reported as absent. Every entity is removed from
+// memcache, whether or not it existed. If checking which exist fails nothing is deleted.
+func (s *Cachestore) DeleteIfExists(c context.Context, key []*datastore.Key) ([]bool, error) {
+	exists, err := s.ExistsMulti(c, key)
+	if err != nil {
+		return nil, err
+	}
+	err = s.DeleteMulti(c, key)
+	if err != nil {
+		return nil, err
+	}
+	return exists, nil
+}
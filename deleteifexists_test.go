@@ -0,0 +1,126 @@
+package cachestore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestDeleteIfExists(t *testing.T) {
+	requireAetest(t)
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, []Struct{{1}, {2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cache the first
+	err = Get(c, key[0], &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key = append(key, datastore.NewKey(c, "Struct", "deleteIfExistsAbsent", 0, nil))
+	// DeleteIfExists: cached, in datastore, absent
+	existed, err := DeleteIfExists(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []bool{true, true, false}
+	if !reflect.DeepEqual(expected, existed) {
+		t.Fatalf("expected=%#v actual=%#v", expected, existed)
+	}
+	// DeleteIfExists again: all absent
+	existed, err = DeleteIfExists(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []bool{false, false, false}
+	if !reflect.DeepEqual(expected, existed) {
+		t.Fatalf("expected=%#v actual=%#v", expected, existed)
+	}
+	err = GetMulti(c, key, make([]Struct, len(key)))
+	if me, ok := err.(appengine.MultiError); !ok || me[0] != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+}
+
+func TestDeleteIfExistsIncomplete(t *testing.T) {
+	withFakeBackends(func(m *fakeMemcache, d *fakeDatastore) {
+		c := context.Background()
+		key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), &Struct{1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// DeleteIfExists with an incomplete key, which DeleteMulti skips
+		existed, err := DeleteIfExists(c, []*datastore.Key{key, datastore.NewIncompleteKey(c, "Struct", nil)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []bool{true, false}
+		if !reflect.DeepEqual(expected, existed) {
+			t.Fatalf("expected=%#v actual=%#v", expected, existed)
+		}
+		err = Get(c, key, &Struct{})
+		if err != datastore.ErrNoSuchEntity {
+			t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+		}
+	})
+}
+
+// strictDeleteDatastore is a fakeDatastore whose DeleteMulti fails with ErrNoSuchEntity for keys without entities.
+type strictDeleteDatastore struct {
+	*fakeDatastore
+}
+
+func (d strictDeleteDatastore) DeleteMulti(c context.Context, key []*datastore.Key) error {
+	exists := make([]bool, len(key))
+	d.mu.Lock()
+	for i, k := range key {
+		_, exists[i] = d.entities[k.Encode()]
+	}
+	d.mu.Unlock()
+	err := d.fakeDatastore.DeleteMulti(c, key)
+	if err != nil {
+		return err
+	}
+	multiErr, any := make(appengine.MultiError, len(key)), false
+	for i := range key {
+		if !exists[i] {
+			multiErr[i], any = datastore.ErrNoSuchEntity, true
+		}
+	}
+	if any {
+		return multiErr
+	}
+	return nil
+}
+
+func TestDeleteIgnoresNoSuchEntity(t *testing.T) {
+	withFakeBackends(func(m *fakeMemcache, d *fakeDatastore) {
+		datastoreBackend = strictDeleteDatastore{d}
+		c := context.Background()
+		// Put
+		present, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), &Struct{I: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := []*datastore.Key{present, datastore.NewKey(c, "Struct", "absent", 0, nil)}
+		// DeleteIfExists
+		existed, err := DeleteIfExists(c, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []bool{true, false}
+		if !reflect.DeepEqual(expected, existed) {
+			t.Fatalf("expected=%#v actual=%#v", expected, existed)
+		}
+		// DeleteMulti
+		err = DeleteMulti(c, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package cachestore
+
+import (
+	"context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// DeleteMultiResult holds the errors of deleting entities from datastore and memcache separately. Each is nil if
+// deleting every entity succeeded, otherwise it has an error or nil for each key.
+type DeleteMultiResult struct {
+	Datastore appengine.MultiError
+	Memcache  appengine.MultiError
+}
+
+// DeleteMultiWithResult is DeleteMulti using the default Cachestore, returning the datastore and memcache errors
+// separately. See Cachestore.DeleteMultiWithResult.
+func DeleteMultiWithResult(c context.Context, key []*datastore.Key) DeleteMultiResult {
+	return defaultCachestore().DeleteMultiWithResult(c, key)
+}
+
+// DeleteMultiWithResult is DeleteMulti, returning the errors of deleting each entity from datastore and memcache
+// separately instead of the first of them. An error that isn't an appengine.MultiError is reported for every key it
+// applies to. Entities are removed from memcache even if deleting them from datastore fails.
+func (s *Cachestore) DeleteMultiWithResult(c context.Context, key []*datastore.Key) DeleteMultiResult {
+	complete, errd, errm := s.deleteEntities(c, key)
+	return DeleteMultiResult{keyErrors(errd, complete, len(key)), keyErrors(errm, complete, len(key))}
+}
+
+// keyErrors returns err, an error for the keys at the complete indexes of n keys, as an error for each of the n keys.
+func keyErrors(err error, complete []int, n int) appengine.MultiError {
+	if err == nil {
+		return nil
+	}
+	me, ok := err.(appengine.MultiError)
+	errs := make(appengine.MultiError, n)
+	for i, j := range complete {
+		if ok {
+			errs[j] = me[i]
+		} else {
+			errs[j] = err
+		}
+	}
+	return errs
+}
@@ -0,0 +1,48 @@
+package cachestore
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestDeleteMultiWithResult(t *testing.T) {
+	requireAetest(t)
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMulti and GetMulti to cache
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = GetMulti(c, key, make([]Struct, len(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := append(key, datastore.NewIncompleteKey(c, "Struct", nil))
+	// DeleteMultiWithResult with datastore failing
+	result := DeleteMultiWithResult(failingContext("datastore_v3", "Delete"), keys)
+	expected := DeleteMultiResult{Datastore: appengine.MultiError{errFailingContext, errFailingContext, nil}}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected=%#v actual=%#v", expected, result)
+	}
+	items, _, err := defaultCachestore().getItems(c, defaultCachestore().encodeKeys(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, len(items))
+	}
+	// DeleteMultiWithResult with memcache failing
+	result = DeleteMultiWithResult(failingContext("memcache", "Delete"), keys)
+	expected = DeleteMultiResult{Memcache: appengine.MultiError{errFailingContext, errFailingContext, nil}}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected=%#v actual=%#v", expected, result)
+	}
+	err = GetMulti(c, key, make([]Struct, len(key)))
+	if me, ok := err.(appengine.MultiError); !ok || me[0] != datastore.ErrNoSuchEntity || me[1] != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+}
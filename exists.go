@@ -0,0 +1,115 @@
+package cachestore
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+// Exists returns whether there's an entity stored for key using the default Cachestore. See Cachestore.Exists.
+func Exists(c context.Context, key *datastore.Key) (bool, error) {
+	return defaultCachestore().Exists(c, key)
+}
+
+// Exists returns whether there's an entity stored for key, without decoding it.
+func (s *Cachestore) Exists(c context.Context, key *datastore.Key) (bool, error) {
+	exists, err := s.ExistsMulti(c, []*datastore.Key{key})
+	if me, ok := err.(appengine.MultiError); ok {
+		return false, me[0]
+	}
+	if err != nil {
+		return false, err
+	}
+	return exists[0], nil
+}
+
+// ExistsMulti is a batch version of Exists. See Cachestore.ExistsMulti.
+func ExistsMulti(c context.Context, key []*datastore.Key) ([]bool, error) {
+	return defaultCachestore().ExistsMulti(c, key)
+}
+
+// ExistsMulti is a batch version of Exists. Cached keys exist without reading from datastore, the others are read
+// from datastore into throwaway PropertyLists since datastore can't check whether an entity exists without reading it.
+// Incomplete keys don't exist, without reading either.
+func (s *Cachestore) ExistsMulti(c context.Context, key []*datastore.Key) ([]bool, error) {
+	exists := make([]bool, len(key))
+	if len(key) == 0 {
+		return exists, nil
+	}
+	// check cache
+	encodedKeys := s.encodeKeys(key)
+	var items map[string]*memcache.Item
+	if !isBypass(c) && transactionFromContext(c) == nil {
+		// transactions read datastore directly, like Get
+		items, _, _ = s.getEntityItems(c, encodedKeys)
+	}
+	missing := *new([]int)
+	for i, k := range encodedKeys {
+		if key[i].Incomplete() {
+			// there can't be an entity for an incomplete key, and datastore would reject the whole batch
+			continue
+		} else if items[k] != nil {
+			exists[i] = true
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return exists, nil
+	}
+	// check datastore
+	missingKey := make([]*datastore.Key, len(missing))
+	for i, j := range missing {
+		missingKey[i] = key[j]
+	}
+	start := time.Now()
+	err := datastoreBackend.GetMulti(c, missingKey, make([]datastore.PropertyList, len(missing)))
+	s.logSlowOp(c, "get", "datastore", len(missingKey), start, err)
+	me, ok := err.(appengine.MultiError)
+	if err != nil && !ok {
+		return nil, err
+	}
+	var multiErr appengine.MultiError
+	for i, j := range missing {
+		if ok && me[i] == datastore.ErrNoSuchEntity {
+			continue
+		} else if ok && me[i] != nil {
+			if multiErr == nil {
+				multiErr = make(appengine.MultiError, len(key))
+			}
+			multiErr[j] = me[i]
+			continue
+		}
+		exists[j] = true
+	}
+	if multiErr != nil {
+		return exists, multiErr
+	}
+	return exists, nil
+}
+
+// Cached returns whether the entities for key are cached using the default Cachestore. See Cachestore.Cached.
+func Cached(c context.Context, key []*datastore.Key) ([]bool, error) {
+	return defaultCachestore().Cached(c, key)
+}
+
+// Cached returns whether the entity for each key is cached, in the local caches or memcache, without decoding it or
+// reading datastore, for example to decide whether to read entities or query them. Incomplete keys and keys of
+// UncachedKinds are never cached. If reading memcache fails, the entities found before it failed are reported
+// cached, along with the error.
+func (s *Cachestore) Cached(c context.Context, key []*datastore.Key) ([]bool, error) {
+	cached := make([]bool, len(key))
+	encodedKeys := s.encodeKeys(key)
+	cachedKeys := s.cachedKeys(key, encodedKeys)
+	if len(cachedKeys) == 0 {
+		return cached, nil
+	}
+	items, _, err := s.getEntityItems(c, cachedKeys)
+	for i, k := range encodedKeys {
+		cached[i] = items[k] != nil
+	}
+	return cached, err
+}
@@ -0,0 +1,84 @@
+package cachestore
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestExists(t *testing.T) {
+	requireAetest(t)
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, []Struct{{1}, {2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cache the first
+	err = Get(c, key[0], &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	absent := datastore.NewKey(c, "Struct", "absent", 0, nil)
+	// ExistsMulti: cached, in datastore, absent
+	exists, err := ExistsMulti(c, append(key, absent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []bool{true, true, false}
+	if !reflect.DeepEqual(expected, exists) {
+		t.Fatalf("expected=%#v actual=%#v", expected, exists)
+	}
+	// Exists
+	for i, k := range append(key, absent) {
+		exists, err := Exists(c, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != expected[i] {
+			t.Fatalf("expected=%#v actual=%#v", expected[i], exists)
+		}
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err = ExistsMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]bool{false, false}, exists) {
+		t.Fatalf("expected=%#v actual=%#v", []bool{false, false}, exists)
+	}
+}
+
+func TestCached(t *testing.T) {
+	requireAetest(t)
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, []Struct{{1}, {2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cache the first
+	err = Get(c, key[0], &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cached: cached, in datastore, absent, incomplete
+	cached, err := Cached(c, append(key, datastore.NewKey(c, "Struct", "absent", 0, nil), datastore.NewIncompleteKey(c, "Struct", nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []bool{true, false, false, false}
+	if !reflect.DeepEqual(expected, cached) {
+		t.Fatalf("expected=%#v actual=%#v", expected, cached)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
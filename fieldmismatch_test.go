@@ -0,0 +1,58 @@
+package cachestore
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// WidgetName has a subset of Widget's fields.
+type WidgetName struct {
+	Name string
+}
+
+func TestIgnoreFieldMismatch(t *testing.T) {
+	requireAetest(t)
+	defer func() { IgnoreFieldMismatch = false }()
+	src := &Widget{Name: "widget", Active: true}
+	// Put
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Widget", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getName := func() {
+		dst := &WidgetName{}
+		err := Get(c, key, dst)
+		if _, ok := err.(*datastore.ErrFieldMismatch); IgnoreFieldMismatch && err != nil || !IgnoreFieldMismatch && !ok {
+			t.Fatalf("IgnoreFieldMismatch=%v: unexpected error %#v", IgnoreFieldMismatch, err)
+		}
+		if dst.Name != src.Name {
+			t.Fatalf("expected=%#v actual=%#v", src.Name, dst.Name)
+		}
+	}
+	for _, ignore := range []bool{false, true} {
+		IgnoreFieldMismatch = ignore
+		err = defaultCachestore().uncache([]*datastore.Key{key}, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Get from datastore into a struct without Active, which still caches the whole entity
+		getName()
+		dst := &Widget{}
+		err = GetMemcacheOnly(c, key, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src, dst) {
+			t.Fatalf("expected=%#v actual=%#v", src, dst)
+		}
+		// Get from memcache into a struct without Active
+		getName()
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
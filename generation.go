@@ -0,0 +1,95 @@
+package cachestore
+
+import (
+	"context"
+	"encoding/binary"
+	"strconv"
+	"time"
+
+	"google.golang.org/appengine/memcache"
+)
+
+// Flush removes everything the default Cachestore has cached in memcache. See Cachestore.Flush.
+func Flush(c context.Context) error {
+	return defaultCachestore().Flush(c)
+}
+
+// Flush removes everything cachestore has cached in memcache, without touching other memcache items. Entities are
+// cached in MemcacheNamespace whatever the namespace of c, so Flush removes them from every datastore namespace.
+//
+// memcache can only flush everything or delete items by key, so cachestore tags every item it caches with a
+// generation kept in memcache, and treats items of any other generation as misses. Flush starts a new generation,
+// leaving the old items for memcache to evict. If the generation itself is evicted a new one is started, which also
+// invalidates everything.
+func (s *Cachestore) Flush(c context.Context) error {
+	for _, l := range s.localCaches(c) {
+		l.clear()
+	}
+	_, err := s.backend().Increment(s.withMemcacheNamespace(c), s.generationKey(), 1, uint64(time.Now().UnixNano()))
+	return err
+}
+
+// generationKey returns the memcache key of the current generation.
+func (s *Cachestore) generationKey() string {
+	return s.KeyPrefix + "cachestore.generation"
+}
+
+// getGeneration returns the current generation, starting one if there isn't one.
+func (s *Cachestore) getGeneration(c context.Context) (uint64, error) {
+	c, cancel := s.withTimeout(s.withMemcacheNamespace(c))
+	defer cancel()
+	item, err := s.backend().Get(c, s.generationKey())
+	if err == memcache.ErrCacheMiss {
+		return s.newGeneration(c)
+	} else if err != nil {
+		return 0, err
+	}
+	if generation, ok := parseGeneration(item); ok {
+		return generation, nil
+	}
+	return s.newGeneration(c)
+}
+
+// newGeneration starts a generation no item could be tagged with: the time stands in for the lost generation's
+// count. If another call started one first, that generation is returned instead.
+func (s *Cachestore) newGeneration(c context.Context) (uint64, error) {
+	c = s.withMemcacheNamespace(c)
+	generation := uint64(time.Now().UnixNano())
+	item := &memcache.Item{Key: s.generationKey(), Value: []byte(strconv.FormatUint(generation, 10))}
+	err := s.backend().Add(c, item)
+	if err == memcache.ErrNotStored {
+		item, err = s.backend().Get(c, s.generationKey())
+		if err != nil {
+			return 0, err
+		}
+		if generation, ok := parseGeneration(item); ok {
+			return generation, nil
+		}
+	}
+	return generation, err
+}
+
+// parseGeneration returns the generation stored in item, and whether there was one. The value is a decimal string so
+// that memcache.Increment can update it.
+func parseGeneration(item *memcache.Item) (uint64, bool) {
+	if item == nil {
+		return 0, false
+	}
+	generation, err := strconv.ParseUint(string(item.Value), 10, 64)
+	return generation, err == nil
+}
+
+// tagGeneration returns value prefixed by generation.
+func tagGeneration(value []byte, generation uint64) []byte {
+	tagged := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(tagged, generation)
+	return append(tagged, value...)
+}
+
+// untagGeneration returns value without its generation prefix, and whether it was tagged with generation.
+func untagGeneration(value []byte, generation uint64) ([]byte, bool) {
+	if len(value) < 8 || binary.BigEndian.Uint64(value) != generation {
+		return nil, false
+	}
+	return value[8:], true
+}
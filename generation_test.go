@@ -0,0 +1,81 @@
+package cachestore
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+func TestFlush(t *testing.T) {
+	requireAetest(t)
+	src := Struct{I: 3}
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load memcache with Get
+	dst := *new(Struct)
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an item that isn't cachestore's
+	other := &memcache.Item{Key: "other", Value: []byte("other")}
+	err = memcache.Set(c, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flush
+	err = Flush(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// remove from datastore
+	err = datastore.Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get misses memcache
+	err = Get(c, key, &dst)
+	if err != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+	// other items are untouched
+	item, err := memcache.Get(c, other.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(item.Value) != string(other.Value) {
+		t.Fatalf("expected=%q actual=%q", other.Value, item.Value)
+	}
+}
+
+func TestLostGenerationInvalidatesItems(t *testing.T) {
+	requireAetest(t)
+	src := Struct{I: 3}
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load memcache with Get
+	dst := *new(Struct)
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// evict the generation and remove from datastore
+	err = memcache.Delete(c, defaultCachestore().generationKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = datastore.Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get misses memcache
+	err = Get(c, key, &dst)
+	if err != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+}
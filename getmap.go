@@ -0,0 +1,45 @@
+package cachestore
+
+import (
+	"context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// GetMap loads the entities for key into a map using the default Cachestore. See Cachestore.GetMap.
+func GetMap(c context.Context, key []*datastore.Key, proto func() interface{}) (map[string]interface{}, error) {
+	return defaultCachestore().GetMap(c, key, proto)
+}
+
+// GetMap loads the entities for key like GetMulti, into new entities returned by proto, and returns them by their
+// keys' Encode. proto must return a struct pointer or a PropertyLoadSaver. Keys without entities are omitted rather
+// than reported as ErrNoSuchEntity. If loading other entities fails the returned appengine.MultiError has their
+// errors, and the map has the entities that were loaded.
+func (s *Cachestore) GetMap(c context.Context, key []*datastore.Key, proto func() interface{}) (map[string]interface{}, error) {
+	dst := make([]interface{}, len(key))
+	for i := range dst {
+		dst[i] = proto()
+	}
+	err := s.GetMulti(c, key, dst)
+	me, ok := err.(appengine.MultiError)
+	if err != nil && !ok {
+		return nil, err
+	}
+	entities, failed := make(map[string]interface{}, len(key)), false
+	for i, k := range key {
+		if ok && me[i] != nil {
+			if me[i] == datastore.ErrNoSuchEntity {
+				me[i] = nil
+			} else {
+				failed = true
+			}
+			continue
+		}
+		entities[k.Encode()] = dst[i]
+	}
+	if failed {
+		return entities, me
+	}
+	return entities, nil
+}
@@ -0,0 +1,34 @@
+package cachestore
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestGetMap(t *testing.T) {
+	requireAetest(t)
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMap with an absent key
+	absent := datastore.NewKey(c, "Struct", "absent", 0, nil)
+	entities, err := GetMap(c, []*datastore.Key{key[0], absent, key[1]}, func() interface{} { return &Struct{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{key[0].Encode(): &src[0], key[1].Encode(): &src[1]}
+	if !reflect.DeepEqual(expected, entities) {
+		t.Fatalf("expected=%#v actual=%#v", expected, entities)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
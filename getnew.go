@@ -0,0 +1,38 @@
+package cachestore
+
+import (
+	"context"
+	"reflect"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// GetMultiNew loads the entities for key into a new slice using the default Cachestore. See Cachestore.GetMultiNew.
+func GetMultiNew(c context.Context, key []*datastore.Key, elemType reflect.Type) (interface{}, error) {
+	return defaultCachestore().GetMultiNew(c, key, elemType)
+}
+
+// GetMultiNew loads the entities for key like GetMulti, into a new []*S where S is elemType, a struct type, and
+// returns it. The pointers of keys whose entities couldn't be loaded, like keys without entities, are nil, and the
+// returned error is GetMulti's, so for those keys it's an appengine.MultiError with ErrNoSuchEntity.
+func (s *Cachestore) GetMultiNew(c context.Context, key []*datastore.Key, elemType reflect.Type) (interface{}, error) {
+	if elemType == nil || elemType.Kind() != reflect.Struct {
+		return nil, datastore.ErrInvalidEntityType
+	}
+	dst := reflect.MakeSlice(reflect.SliceOf(reflect.PtrTo(elemType)), len(key), len(key))
+	for i := range key {
+		dst.Index(i).Set(reflect.New(elemType))
+	}
+	err := s.GetMulti(c, key, dst.Interface())
+	me, ok := err.(appengine.MultiError)
+	if err != nil && !ok {
+		return nil, err
+	}
+	for i, err := range me {
+		if _, mismatch := err.(*datastore.ErrFieldMismatch); err != nil && !mismatch {
+			dst.Index(i).Set(reflect.Zero(dst.Type().Elem()))
+		}
+	}
+	return dst.Interface(), err
+}
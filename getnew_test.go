@@ -0,0 +1,50 @@
+package cachestore
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestGetMultiNew(t *testing.T) {
+	requireAetest(t)
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMultiNew with an absent key
+	absent := datastore.NewKey(c, "Struct", "absent", 0, nil)
+	dst, err := GetMultiNew(c, []*datastore.Key{key[0], absent, key[1]}, reflect.TypeOf(Struct{}))
+	expectedErr := appengine.MultiError{nil, datastore.ErrNoSuchEntity, nil}
+	if !reflect.DeepEqual(expectedErr, err) {
+		t.Fatalf("expected=%#v actual=%#v", expectedErr, err)
+	}
+	expected := []*Struct{&src[0], nil, &src[1]}
+	if !reflect.DeepEqual(expected, dst) {
+		t.Fatalf("expected=%#v actual=%#v", expected, dst)
+	}
+	// GetMultiNew from memcache
+	dst, err = GetMultiNew(c, key, reflect.TypeOf(Struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []*Struct{&src[0], &src[1]}
+	if !reflect.DeepEqual(expected, dst) {
+		t.Fatalf("expected=%#v actual=%#v", expected, dst)
+	}
+	// GetMultiNew of a non-struct type
+	_, err = GetMultiNew(c, key, reflect.TypeOf(&Struct{}))
+	if err != datastore.ErrInvalidEntityType {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrInvalidEntityType, err)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
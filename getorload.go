@@ -0,0 +1,67 @@
+package cachestore
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+// GetOrLoad loads the value cached for cacheKey into dst, loading it with loader if it isn't cached, using the default
+// Cachestore. See Cachestore.GetOrLoad.
+func GetOrLoad(c context.Context, cacheKey string, dst interface{}, ttl time.Duration, loader func() (interface{}, error)) error {
+	return defaultCachestore().GetOrLoad(c, cacheKey, dst, ttl, loader)
+}
+
+// GetOrLoad loads the value cached for cacheKey into dst, which must be a struct pointer or implement
+// PropertyLoadSaver. If nothing is cached for cacheKey, GetOrLoad calls loader and caches the value it returns for
+// ttl (zero means no expiration) before loading it into dst. The value must satisfy the same conditions as dst.
+//
+// If loader returns an error nothing is cached and the error is returned. Concurrent calls for the same cacheKey
+// share a single call to loader. Values are cached per namespace, like queries: calls whose contexts have different
+// namespaces don't share values for the same cacheKey. With a ReadOnly context or MemcacheReadOnly set, the value
+// loader returns isn't cached.
+func (s *Cachestore) GetOrLoad(c context.Context, cacheKey string, dst interface{}, ttl time.Duration, loader func() (interface{}, error)) error {
+	// namespaces can't contain ':', so the namespace and cacheKey can't run together
+	namespace := datastore.NewKey(c, "", "", 0, nil).Namespace()
+	key := []string{s.KeyPrefix + "load:" + namespace + ":" + cacheKey}
+	// check cache
+	items, generation, _ := s.getItems(c, key)
+	if item := items[key[0]]; item != nil {
+		return s.decode(dst, item.Value)
+	}
+	// load, sharing the load with concurrent calls
+	loads, lead, _ := inflight.start(key, []int{0})
+	l := loads[0]
+	var errm error
+	if len(lead) > 0 {
+		defer inflight.finish(key[0], l)
+		var src interface{}
+		var value []byte
+		src, l.err = loader()
+		if l.err == nil {
+			l.properties, l.err = saveProperties(src)
+		}
+		if l.err == nil {
+			value, l.err = s.codec().Marshal(l.properties)
+		}
+		inflight.finish(key[0], l)
+		// cache for next time
+		if l.err == nil && !s.readOnly(c) {
+			item := s.newItem(key[0], value)
+			item.Expiration = ttl
+			errm = s.setItems(c, []*memcache.Item{item}, generation)
+		}
+	} else {
+		<-l.done
+	}
+	if l.err != nil {
+		return l.err
+	}
+	// a copy, since the properties are shared with concurrent calls
+	if err := s.loadProperties(dst, append([]datastore.Property(nil), l.properties...)); err != nil {
+		return err
+	}
+	return errm
+}
@@ -0,0 +1,58 @@
+package cachestore
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	requireAetest(t)
+	loads := 0
+	loader := func() (interface{}, error) {
+		loads++
+		return &Struct{I: 3}, nil
+	}
+	// miss
+	dst := *new(Struct)
+	err := GetOrLoad(c, "TestGetOrLoad", &dst, 0, loader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(Struct{I: 3}, dst) || loads != 1 {
+		t.Fatalf("expected=%#v after 1 load actual=%#v after %d loads", Struct{I: 3}, dst, loads)
+	}
+	// hit
+	dst = *new(Struct)
+	err = GetOrLoad(c, "TestGetOrLoad", &dst, 0, loader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(Struct{I: 3}, dst) || loads != 1 {
+		t.Fatalf("expected=%#v after 1 load actual=%#v after %d loads", Struct{I: 3}, dst, loads)
+	}
+}
+
+func TestGetOrLoadDoesNotCacheErrors(t *testing.T) {
+	requireAetest(t)
+	errLoad := errors.New("load")
+	dst := *new(Struct)
+	err := GetOrLoad(c, "TestGetOrLoadDoesNotCacheErrors", &dst, 0, func() (interface{}, error) {
+		return nil, errLoad
+	})
+	if err != errLoad {
+		t.Fatalf("expected=%#v actual=%#v", errLoad, err)
+	}
+	// the next call loads again
+	loads := 0
+	err = GetOrLoad(c, "TestGetOrLoadDoesNotCacheErrors", &dst, 0, func() (interface{}, error) {
+		loads++
+		return &Struct{I: 3}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loads != 1 {
+		t.Fatalf("expected=1 load actual=%d", loads)
+	}
+}
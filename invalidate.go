@@ -0,0 +1,30 @@
+package cachestore
+
+import (
+	"context"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// InvalidateAncestor removes the entities under ancestor from memcache using the default Cachestore. See
+// Cachestore.InvalidateAncestor.
+func InvalidateAncestor(c context.Context, ancestor *datastore.Key, kind string) error {
+	return defaultCachestore().InvalidateAncestor(c, ancestor, kind)
+}
+
+// InvalidateAncestor removes the entities under ancestor (including ancestor itself) from memcache, for example after
+// deleting ancestor. If kind isn't empty only entities of that kind are removed.
+//
+// Memcache can't look up items by key prefix, so the keys are found with a keys-only datastore query. This is
+// best-effort: entities put under ancestor concurrently may be missed and cached by a concurrent Get after they're
+// removed.
+func (s *Cachestore) InvalidateAncestor(c context.Context, ancestor *datastore.Key, kind string) error {
+	key, err := datastoreBackend.GetAll(c, datastore.NewQuery(kind).Ancestor(ancestor).KeysOnly(), nil)
+	if err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return nil
+	}
+	return s.evict(c, key)
+}
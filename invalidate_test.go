@@ -0,0 +1,61 @@
+package cachestore
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestInvalidateAncestor(t *testing.T) {
+	requireAetest(t)
+	parent := datastore.NewKey(c, "Parent", "invalidate", 0, nil)
+	key := []*datastore.Key{
+		datastore.NewIncompleteKey(c, "Struct", parent),
+		datastore.NewIncompleteKey(c, "Struct", parent),
+		datastore.NewIncompleteKey(c, "Other", parent),
+	}
+	src := []Struct{{1}, {2}, {3}}
+	// Put
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cache
+	dst := make([]Struct, len(src))
+	err = GetMulti(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// InvalidateAncestor with kind
+	err = InvalidateAncestor(c, parent, "Struct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, _, err := defaultCachestore().getItems(c, defaultCachestore().encodeKeys(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items[defaultCachestore().encodeKey(key[0])] != nil || items[defaultCachestore().encodeKey(key[1])] != nil {
+		t.Fatalf("expected descendants of kind Struct to be removed from memcache")
+	}
+	if items[defaultCachestore().encodeKey(key[2])] == nil {
+		t.Fatalf("expected descendants of kind Other to stay in memcache")
+	}
+	// InvalidateAncestor without kind
+	err = InvalidateAncestor(c, parent, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, _, err = defaultCachestore().getItems(c, defaultCachestore().encodeKeys(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, len(items))
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,24 @@
+package cachestore
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// randFloat64 returns a random number in [0, 1), tests replace it to make jitter deterministic.
+var randFloat64 = rand.Float64
+
+// jitter returns expiration randomly lengthened or shortened by at most ExpirationJitter of it, so that items cached
+// together don't all expire together.
+func (s *Cachestore) jitter(expiration time.Duration) time.Duration {
+	if expiration <= 0 || s.ExpirationJitter <= 0 {
+		return expiration
+	}
+	fraction := math.Min(s.ExpirationJitter, 1)
+	jittered := expiration + time.Duration((2*randFloat64()-1)*fraction*float64(expiration))
+	if jittered <= 0 {
+		return expiration
+	}
+	return jittered
+}
@@ -0,0 +1,55 @@
+package cachestore
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestExpirationJitter(t *testing.T) {
+	// deterministic random numbers spread over [0, 1)
+	n := 0
+	randFloat64 = func() float64 {
+		n++
+		return float64(n%100) / 100
+	}
+	defer func() { randFloat64 = rand.Float64 }()
+	s := &Cachestore{Expiration: 100 * time.Second, ExpirationJitter: 0.1}
+	src := make([]Struct, 100)
+	key := make([]*datastore.Key, len(src))
+	for i := range key {
+		key[i] = datastore.NewKey(c, "Struct", "", int64(i+1), nil)
+	}
+	items, err := s.encodeItems(key, src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	min, max := s.Expiration, s.Expiration
+	for _, item := range items {
+		if item.Expiration < 90*time.Second || item.Expiration > 110*time.Second {
+			t.Fatalf("expected an expiration within 10s of %v actual=%v", s.Expiration, item.Expiration)
+		}
+		if item.Expiration < min {
+			min = item.Expiration
+		}
+		if item.Expiration > max {
+			max = item.Expiration
+		}
+	}
+	if max-min < 19*time.Second {
+		t.Fatalf("expected expirations spread over 20s actual=%v", max-min)
+	}
+	// no jitter without an expiration
+	s.Expiration = 0
+	items, err = s.encodeItems(key, src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		if item.Expiration != 0 {
+			t.Fatalf("expected=%#v actual=%#v", 0, item.Expiration)
+		}
+	}
+}
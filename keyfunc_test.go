@@ -0,0 +1,70 @@
+package cachestore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestKeyFunc(t *testing.T) {
+	requireAetest(t)
+	defer func() { KeyFunc = nil }()
+	// a key whose encoding is too long for memcache
+	var key *datastore.Key
+	for i := 0; i < 5; i++ {
+		key = datastore.NewKey(c, "Struct", strings.Repeat("ancestor", 10), 0, key)
+	}
+	if encoded := defaultCachestore().encodeKey(key); len(encoded) <= 250 {
+		t.Fatalf("expected a key longer than 250 bytes, actual=%d", len(encoded))
+	}
+	tests := []struct {
+		keyFunc func(*datastore.Key) string
+		reads   uint64
+	}{
+		{nil, 1}, // can't be cached
+		{func(key *datastore.Key) string {
+			sum := sha256.Sum256([]byte(key.Encode()))
+			return hex.EncodeToString(sum[:])
+		}, 0},
+	}
+	for _, test := range tests {
+		KeyFunc = test.keyFunc
+		src := &Struct{I: 3}
+		// Put
+		_, err := Put(c, key, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Get from datastore
+		err = Get(c, key, &Struct{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Get again
+		before := Stats()
+		dst := &Struct{}
+		err = Get(c, key, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src, dst) {
+			t.Fatalf("expected=%#v actual=%#v", src, dst)
+		}
+		if reads := Stats().DatastoreReads - before.DatastoreReads; reads != test.reads {
+			t.Fatalf("expected=%#v actual=%#v", test.reads, reads)
+		}
+		// Delete
+		err = Delete(c, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Get(c, key, &Struct{})
+		if err != datastore.ErrNoSuchEntity {
+			t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package cachestore
+
+import (
+	"context"
+	"sync/atomic"
+
+	"google.golang.org/appengine/log"
+)
+
+// Logger logs cachestore's debug info.
+type Logger interface {
+	Debugf(c context.Context, format string, args ...interface{})
+}
+
+// AppEngineLogger is a Logger that writes to the App Engine request log.
+var AppEngineLogger Logger = appEngineLogger{}
+
+var logger atomic.Value // holds a loggerValue
+
+// loggerValue wraps Loggers so that logger always stores the same concrete type.
+type loggerValue struct {
+	Logger
+}
+
+func init() {
+	SetLogger(nil)
+}
+
+// SetLogger sets the Logger that cachestore's debug info is written to. A nil Logger disables logging, which is the
+// default. It is safe to call SetLogger concurrently with other cachestore functions.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	logger.Store(loggerValue{l})
+}
+
+// debugf writes debug info to s's Logger if it has one, otherwise to the Logger set by SetLogger.
+func (s *Cachestore) debugf(c context.Context, format string, args ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Debugf(c, format, args...)
+	} else {
+		logger.Load().(loggerValue).Debugf(c, format, args...)
+	}
+}
+
+type appEngineLogger struct{}
+
+func (appEngineLogger) Debugf(c context.Context, format string, args ...interface{}) {
+	log.Debugf(c, format, args...)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(c context.Context, format string, args ...interface{}) {}
@@ -0,0 +1,85 @@
+package cachestore
+
+import (
+	"container/list"
+	"sync"
+)
+
+// LRU is an in-process cache of encoded entities, checked before memcache. It holds at most a fixed number of
+// entities, evicting the least recently used ones.
+//
+// Put, Delete and Flush only update the LRU of the instance they're called in, so other instances' LRUs may return
+// stale entities until they're evicted. Only use an LRU for entities that rarely change, or that may be stale.
+type LRU struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element // elements hold *lruEntry
+	order   *list.List               // most recently used first
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	version uint16
+}
+
+// NewLRU returns an LRU holding at most size entities.
+func NewLRU(size int) *LRU {
+	return &LRU{size: size, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// get returns the value cached for key with version, and whether there was one.
+func (l *LRU) get(key string, version uint16) ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*lruEntry)
+	if entry.version != version {
+		return nil, false
+	}
+	l.order.MoveToFront(e)
+	return entry.value, true
+}
+
+// add caches value for key with version, evicting the least recently used entry if the LRU is full.
+func (l *LRU) add(key string, value []byte, version uint16) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.entries[key]; ok {
+		e.Value = &lruEntry{key, value, version}
+		l.order.MoveToFront(e)
+		return
+	}
+	if l.size <= 0 {
+		return
+	}
+	if l.order.Len() >= l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(*lruEntry).key)
+	}
+	l.entries[key] = l.order.PushFront(&lruEntry{key, value, version})
+}
+
+// remove removes the values cached for key.
+func (l *LRU) remove(key []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, k := range key {
+		if e, ok := l.entries[k]; ok {
+			l.order.Remove(e)
+			delete(l.entries, k)
+		}
+	}
+}
+
+// clear removes everything.
+func (l *LRU) clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = make(map[string]*list.Element)
+	l.order.Init()
+}
@@ -0,0 +1,89 @@
+package cachestore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestLocalCache(t *testing.T) {
+	requireAetest(t)
+	LocalCache = NewLRU(10)
+	defer func() { LocalCache = nil }()
+	src := &Struct{I: 3}
+	// Put
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get from datastore
+	dst := &Struct{}
+	err = Get(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get with memcache and datastore failing
+	down := appengine.WithAPICallFunc(c, func(ctx context.Context, s, m string, in, out proto.Message) error {
+		if m == "Get" {
+			return errFailingContext
+		}
+		return appengine.APICall(ctx, s, m, in, out)
+	})
+	dst = &Struct{}
+	err = Get(down, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// Put evicts
+	src = &Struct{I: 4}
+	_, err = Put(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := LocalCache.get(defaultCachestore().encodeKey(key), 0); ok {
+		t.Fatal("expected Put to evict from the local cache")
+	}
+	dst = &Struct{}
+	err = Get(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Get(c, key, &Struct{})
+	if err != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	l := NewLRU(2)
+	l.add("a", []byte("a"), 0)
+	l.add("b", []byte("b"), 0)
+	l.get("a", 0)
+	l.add("c", []byte("c"), 0)
+	if _, ok := l.get("b", 0); ok {
+		t.Fatal("expected b to be evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if v, ok := l.get(k, 0); !ok || string(v) != k {
+			t.Fatalf("expected=%#v actual=%#v", k, string(v))
+		}
+	}
+	if _, ok := l.get("a", 1); ok {
+		t.Fatal("expected a different version to miss")
+	}
+}
@@ -2,151 +2,830 @@ package cachestore
 
 import (
 	"bytes"
-	"encoding/gob"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"reflect"
+	"time"
 
-	"appengine"
-	"appengine/datastore"
-	"appengine/memcache"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
 )
 
+const (
+	maxItemSize           = 1 << 20            // memcache's limit on the size of an item's key and value
+	maxKeySize            = 250                // memcache's limit on the size of a key
+	chunkSize             = maxItemSize - 1024 // leaves room for the chunk's key
+	flagChunked    uint32 = 1 << 0             // set on manifest items whose value is split across chunk items
+	flagCompressed uint32 = 1 << 1             // set on items whose value is compressed
+	flagLocked     uint32 = 1 << 2             // set on lock items added while an entity is read from datastore
+	flagWritten    uint32 = 1 << 3             // set on items whose value is prefixed by when it was cached
+	flagStale      uint32 = 1 << 4             // set by getItems on items cached more than RefreshAfter ago
+	versionShift          = 16                 // Version is stored in the flags' upper bits
+	itemFlagsMask  uint32 = 0xffe0             // the flags' bits between cachestore's own and Version, for ItemFlags
+
+	lockExpiration = time.Minute // longer than reading from datastore while holding a lock should take
+)
+
+// uncached returns whether the entity for key is never cached, because its kind is in UncachedKinds.
+func (s *Cachestore) uncached(key *datastore.Key) bool {
+	return s.UncachedKinds[key.Kind()]
+}
+
+// cachedKeys returns the distinct encoded keys of the entities that may be cached, given the keys and their
+// encodings.
+func (s *Cachestore) cachedKeys(key []*datastore.Key, encodedKeys []string) []string {
+	cached, seen := make([]string, 0, len(key)), make(map[string]bool, len(key))
+	for i, k := range key {
+		if !seen[encodedKeys[i]] && !s.uncached(k) {
+			cached = append(cached, encodedKeys[i])
+			seen[encodedKeys[i]] = true
+		}
+	}
+	return cached
+}
+
 // encodeKeys returns an array of string encoded datastore.Keys
-func encodeKeys(key []*datastore.Key) []string {
+func (s *Cachestore) encodeKeys(key []*datastore.Key) []string {
 	encodedKeys := make([]string, len(key))
 	for i, k := range key {
-		encodedKeys[i] = k.Encode()
+		encodedKeys[i] = s.encodeKey(k)
 	}
 	return encodedKeys
 }
 
-// cache writes structs and PropertyLoadSavers to memcache.
-func cache(key []*datastore.Key, src interface{}, c appengine.Context) error {
-	items, err := encodeItems(key, src)
-	if len(items) > 0 && err == nil {
-		if Debug {
-			c.Debugf("writing to memcache: %#v", src)
+// encodeKey returns the memcache key for a datastore.Key
+func (s *Cachestore) encodeKey(key *datastore.Key) string {
+	if s.KeyFunc != nil {
+		return s.KeyPrefix + s.KeyFunc(key)
+	}
+	return s.KeyPrefix + key.Encode()
+}
+
+// cache writes structs and PropertyLoadSavers to memcache, with the expirations of opts if it isn't nil.
+func (s *Cachestore) cache(key []*datastore.Key, src interface{}, opts []PutOption, c context.Context) error {
+	if s.MemcacheReadOnly {
+		return nil
+	}
+	items, err := s.encodeItems(key, src, opts)
+	if err != nil || len(items) == 0 {
+		return err
+	}
+	generation, err := s.getGeneration(c)
+	if err != nil {
+		return err
+	}
+	return s.setItems(c, items, generation)
+}
+
+// withTimeout returns a context for memcache calls that times out after MemcacheTimeout, if it's set.
+func (s *Cachestore) withTimeout(c context.Context) (context.Context, context.CancelFunc) {
+	if s.MemcacheTimeout <= 0 {
+		return c, func() {}
+	}
+	return context.WithTimeout(c, s.MemcacheTimeout)
+}
+
+// getMulti is memcache.GetMulti, split into calls of at most MemcacheBatchSize keys, each retried like retry. Missing
+// keys are left out of the result, memcache.ErrCacheMiss isn't an error. If a call fails, the items may be missing
+// some of the keys that are cached.
+func (s *Cachestore) getMulti(c context.Context, key []string) (map[string]*memcache.Item, error) {
+	c = s.withMemcacheNamespace(c)
+	start, items := time.Now(), make(map[string]*memcache.Item, len(key))
+	err := s.batch(len(key), func(i, j int) error {
+		return s.retry(c, func() error {
+			batchItems, err := s.backend().GetMulti(c, key[i:j])
+			for k, item := range batchItems {
+				items[k] = item
+			}
+			if err == memcache.ErrCacheMiss {
+				return nil
+			}
+			return err
+		})
+	})
+	s.logSlowOp(c, "get", "memcache", len(key), start, err)
+	return items, err
+}
+
+// setMulti calls set, memcache.SetMulti, AddMulti or CompareAndSwapMulti, on batches of at most MemcacheBatchSize
+// items, each retried like retry.
+func (s *Cachestore) setMulti(c context.Context, set func(context.Context, []*memcache.Item) error, items []*memcache.Item) error {
+	c = s.withMemcacheNamespace(c)
+	start := time.Now()
+	err := s.batch(len(items), func(i, j int) error {
+		return s.retry(c, func() error {
+			return set(c, items[i:j])
+		})
+	})
+	s.logSlowOp(c, "put", "memcache", len(items), start, err)
+	return err
+}
+
+// deleteMulti is memcache.DeleteMulti, split into calls of at most MemcacheBatchSize keys, each retried like retry.
+func (s *Cachestore) deleteMulti(c context.Context, key []string) error {
+	c = s.withMemcacheNamespace(c)
+	start := time.Now()
+	err := s.batch(len(key), func(i, j int) error {
+		return s.retry(c, func() error {
+			return s.backend().DeleteMulti(c, key[i:j])
+		})
+	})
+	s.logSlowOp(c, "delete", "memcache", len(key), start, err)
+	return err
+}
+
+// batch calls f for consecutive ranges [i, j) of at most MemcacheBatchSize of n items. See batch.
+func (s *Cachestore) batch(n int, f func(i, j int) error) error {
+	return batch(n, s.MemcacheBatchSize, f)
+}
+
+// batch calls f for consecutive ranges [i, j) of at most size of n items, zero meaning no maximum. It merges the
+// appengine.MultiErrors f returns into one for all n items, and stops at the first other error.
+func batch(n, size int, f func(i, j int) error) error {
+	if size <= 0 || n <= size {
+		return f(0, n)
+	}
+	var multiErr appengine.MultiError
+	for i := 0; i < n; i += size {
+		j := i + size
+		if j > n {
+			j = n
+		}
+		err := f(i, j)
+		if me, ok := err.(appengine.MultiError); ok {
+			if multiErr == nil {
+				multiErr = make(appengine.MultiError, n)
+			}
+			copy(multiErr[i:j], me)
+		} else if err != nil {
+			return err
+		}
+	}
+	if multiErr != nil {
+		return multiErr
+	}
+	return nil
+}
+
+// setItems writes items to memcache tagged with generation, splitting the ones that are too large.
+func (s *Cachestore) setItems(c context.Context, items []*memcache.Item, generation uint64) error {
+	if len(items) == 0 {
+		return nil
+	}
+	c, cancel := s.withTimeout(c)
+	defer cancel()
+	s.debugf(c, "writing to memcache: %d items", len(items))
+	split := splitItems(tagItems(items, generation))
+	err := s.setMulti(c, s.backend().SetMulti, split)
+	s.logItemErrors(c, split, err)
+	return err
+}
+
+// logItemErrors logs which of items failed to be written by a memcache call that returned err.
+func (s *Cachestore) logItemErrors(c context.Context, items []*memcache.Item, err error) {
+	if me, ok := err.(appengine.MultiError); ok {
+		for i, e := range me {
+			if e != nil {
+				s.debugf(c, "writing to memcache: %s: %v", items[i].Key, e)
+			}
+		}
+	} else if err != nil {
+		s.debugf(c, "writing to memcache: %v", err)
+	}
+}
+
+// countItemErrors returns how many of n items failed to be written by a memcache call that returned err.
+func countItemErrors(n int, err error) int {
+	me, ok := err.(appengine.MultiError)
+	if !ok {
+		if err != nil {
+			return n
+		}
+		return 0
+	}
+	failed := 0
+	for _, e := range me {
+		if e != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+// tagItems returns copies of items with their values tagged with generation.
+func tagItems(items []*memcache.Item, generation uint64) []*memcache.Item {
+	tagged := make([]*memcache.Item, len(items))
+	for i, item := range items {
+		tagged[i] = &memcache.Item{
+			Key:        item.Key,
+			Value:      tagGeneration(item.Value, generation),
+			Flags:      item.Flags,
+			Expiration: item.Expiration,
+		}
+	}
+	return tagged
+}
+
+// lockItems adds lock items for the keys, unless they're already locked, and returns the ones it added with the CAS
+// ids needed to replace them. Put and Delete remove locks along with the items they evict, so casItems only caches
+// values read from datastore if no write happened since the keys were locked.
+func (s *Cachestore) lockItems(c context.Context, key []string) map[string]*memcache.Item {
+	if len(key) == 0 {
+		return nil
+	}
+	c, cancel := s.withTimeout(c)
+	defer cancel()
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil
+	}
+	locks := make([]*memcache.Item, len(key))
+	for i, k := range key {
+		locks[i] = &memcache.Item{Key: k, Value: token, Flags: flagLocked | s.itemFlags(), Expiration: lockExpiration}
+	}
+	// keys that are already in memcache or locked by another call fail with ErrNotStored
+	s.setMulti(c, s.backend().AddMulti, locks)
+	items, err := s.getMulti(c, key)
+	if err != nil {
+		return nil
+	}
+	// the items that aren't locks are stale, or were cached since the caller missed them, so replace them too
+	var stale []*memcache.Item
+	var staleKeys []string
+	for k, item := range items {
+		if item.Flags&flagLocked == 0 {
+			item.Value, item.Flags, item.Expiration = token, flagLocked|s.itemFlags(), lockExpiration
+			stale, staleKeys = append(stale, item), append(staleKeys, k)
+		}
+	}
+	if len(stale) > 0 {
+		s.setMulti(c, s.backend().CompareAndSwapMulti, stale)
+		relocked, err := s.getMulti(c, staleKeys)
+		if err != nil {
+			return nil
+		}
+		for _, k := range staleKeys {
+			if item, ok := relocked[k]; ok {
+				items[k] = item
+			} else {
+				delete(items, k)
+			}
+		}
+	}
+	for k, item := range items {
+		if item.Flags&flagLocked == 0 || !bytes.Equal(item.Value, token) {
+			delete(items, k)
+		}
+	}
+	return items
+}
+
+// casItems replaces locks with the matching items tagged with generation, like setItems. Items without a lock, or
+// whose lock was removed or replaced since lockItems, aren't written.
+func (s *Cachestore) casItems(c context.Context, items []*memcache.Item, locks map[string]*memcache.Item, generation uint64) error {
+	locked := *new([]*memcache.Item)
+	for _, item := range items {
+		if locks[item.Key] != nil {
+			locked = append(locked, item)
+		}
+	}
+	if len(locked) == 0 {
+		return nil
+	}
+	c, cancel := s.withTimeout(c)
+	defer cancel()
+	s.debugf(c, "writing to memcache: %d items", len(locked))
+	var chunks, swaps []*memcache.Item
+	for _, item := range splitItems(tagItems(locked, generation)) {
+		if lock := locks[item.Key]; lock != nil {
+			lock.Value, lock.Flags, lock.Expiration = item.Value, item.Flags, item.Expiration
+			swaps = append(swaps, lock)
+		} else {
+			chunks = append(chunks, item)
+		}
+	}
+	// chunk keys include the value's checksum, so they can be written before the manifest replaces the lock
+	if len(chunks) > 0 {
+		if err := s.setMulti(c, s.backend().SetMulti, chunks); err != nil {
+			s.logItemErrors(c, chunks, err)
+			return err
+		}
+	}
+	err := s.setMulti(c, s.backend().CompareAndSwapMulti, swaps)
+	s.addLocalItems(c, locked, err)
+	if me, ok := err.(appengine.MultiError); ok {
+		// a removed or replaced lock isn't a failure
+		any := false
+		for i, e := range me {
+			if e == memcache.ErrCASConflict || e == memcache.ErrNotStored {
+				me[i] = nil
+			} else if e != nil {
+				any = true
+			}
+		}
+		if !any {
+			return nil
+		}
+	}
+	s.logItemErrors(c, swaps, err)
+	return err
+}
+
+// localCaches returns the in-process caches of entities to check before memcache, in order: the request cache of c
+// and LocalCache, if they're set.
+func (s *Cachestore) localCaches(c context.Context) []*LRU {
+	caches := *new([]*LRU)
+	if r := requestCacheFromContext(c); r != nil {
+		caches = append(caches, r)
+	}
+	if s.LocalCache != nil {
+		caches = append(caches, s.LocalCache)
+	}
+	return caches
+}
+
+// removeLocal removes the entities for the encoded keys from the local caches of c.
+func (s *Cachestore) removeLocal(c context.Context, encodedKeys []string) {
+	for _, l := range s.localCaches(c) {
+		l.remove(encodedKeys)
+	}
+}
+
+// addLocalItems adds the items whose locks were swapped with err to the local caches of c. err is the error of
+// swapping the locks of items, in the same order. Local caches hold values as getItems returns them, uncompressed and
+// unstamped.
+func (s *Cachestore) addLocalItems(c context.Context, items []*memcache.Item, err error) {
+	caches := s.localCaches(c)
+	if len(caches) == 0 {
+		return
+	}
+	me, ok := err.(appengine.MultiError)
+	if err != nil && !ok {
+		return
+	}
+	for i, item := range items {
+		if me != nil && me[i] != nil {
+			continue
+		}
+		value := item.Value
+		if item.Flags&flagCompressed != 0 {
+			if value, err = decompress(value); err != nil {
+				continue
+			}
+		}
+		if item.Flags&flagWritten != 0 {
+			if _, value, ok = unstampWritten(value); !ok {
+				continue
+			}
+		}
+		for _, l := range caches {
+			l.add(item.Key, value, s.Version)
+		}
+	}
+}
+
+// getEntityItems is getItems for the items of entities. It checks the local caches of c before memcache, and adds
+// the items found in memcache, or in a later local cache, to the earlier ones.
+func (s *Cachestore) getEntityItems(c context.Context, key []string) (map[string]*memcache.Item, uint64, error) {
+	caches := s.localCaches(c)
+	if len(caches) == 0 {
+		return s.getItems(c, key)
+	}
+	local, remote := make(map[string]*memcache.Item), *new([]string)
+	for _, k := range key {
+		found := false
+		for i, l := range caches {
+			if value, ok := l.get(k, s.Version); ok {
+				for _, earlier := range caches[:i] {
+					earlier.add(k, value, s.Version)
+				}
+				local[k], found = &memcache.Item{Key: k, Value: value}, true
+				break
+			}
+		}
+		if !found {
+			remote = append(remote, k)
+		}
+	}
+	if len(remote) == 0 {
+		return local, 0, nil
+	}
+	items, generation, err := s.getItems(c, remote)
+	for k, item := range items {
+		if err == nil {
+			for _, l := range caches {
+				l.add(k, item.Value, s.Version)
+			}
+		}
+		local[k] = item
+	}
+	return local, generation, err
+}
+
+// getItems gets the items for key from memcache, reassembling chunked items and decompressing compressed ones. Items
+// that can't be reassembled or decompressed, or that aren't of the current generation, are left out of the result.
+// Items cached more than RefreshAfter ago are flagged with flagStale. It also returns the current generation. If
+// memcache fails no items are returned, so callers read all the keys from datastore.
+func (s *Cachestore) getItems(c context.Context, key []string) (map[string]*memcache.Item, uint64, error) {
+	c, cancel := s.withTimeout(c)
+	defer cancel()
+	genKey := s.generationKey()
+	items, err := s.getMulti(c, append(key[:len(key):len(key)], genKey))
+	if err != nil {
+		// the items returned with the error may be missing some keys, like the generation, so they can't be trusted
+		return map[string]*memcache.Item{}, 0, err
+	}
+	generation, ok := parseGeneration(items[genKey])
+	delete(items, genKey)
+	if !ok {
+		// the generation was lost, so none of the items can be trusted
+		generation, err = s.newGeneration(c)
+		return map[string]*memcache.Item{}, generation, err
+	}
+	chunkKeys := *new([]string)
+	for _, item := range items {
+		if item.Flags&flagChunked != 0 {
+			if checksum, n, ok := parseManifest(item); ok {
+				for i := 0; i < n; i++ {
+					chunkKeys = append(chunkKeys, chunkKey(item.Key, checksum, i))
+				}
+			}
 		}
-		err = memcache.SetMulti(c, items)
+	}
+	var chunks map[string]*memcache.Item
+	if len(chunkKeys) > 0 {
+		chunks, _ = s.getMulti(c, chunkKeys)
+	}
+	for k, item := range items {
+		if item.Flags&flagLocked != 0 {
+			// being read from datastore by another call
+			delete(items, k)
+			continue
+		}
+		if item.Flags>>versionShift != uint32(s.Version) {
+			delete(items, k)
+			continue
+		}
+		if item.Flags&flagChunked != 0 {
+			joined, ok := joinChunks(item, chunks)
+			if !ok {
+				delete(items, k)
+				continue
+			}
+			item = joined
+		}
+		value, ok := untagGeneration(item.Value, generation)
+		if !ok {
+			delete(items, k)
+			continue
+		}
+		item = &memcache.Item{Key: item.Key, Value: value, Flags: item.Flags}
+		if item.Flags&flagCompressed != 0 {
+			value, err := decompress(item.Value)
+			if err != nil {
+				delete(items, k)
+				continue
+			}
+			item = &memcache.Item{Key: item.Key, Value: value, Flags: item.Flags &^ flagCompressed}
+		}
+		if item.Flags&flagWritten != 0 {
+			written, value, ok := unstampWritten(item.Value)
+			if !ok {
+				delete(items, k)
+				continue
+			}
+			flags := item.Flags &^ flagWritten
+			if s.RefreshAfter > 0 && now().Sub(written) >= s.RefreshAfter {
+				flags |= flagStale
+			}
+			item = &memcache.Item{Key: item.Key, Value: value, Flags: flags}
+		}
+		items[k] = item
+	}
+	return items, generation, nil
+}
+
+// itemFlags returns the ItemFlags that don't clash with cachestore's own flags.
+func (s *Cachestore) itemFlags() uint32 {
+	return s.ItemFlags & itemFlagsMask
+}
+
+// newItem returns a memcache item for key and the encoded value, stamping value with the time if RefreshAfter is set
+// and compressing it if shouldCompress says so.
+func (s *Cachestore) newItem(key string, value []byte) *memcache.Item {
+	item := &memcache.Item{Key: key, Value: value, Flags: uint32(s.Version)<<versionShift | s.itemFlags(), Expiration: s.jitter(s.Expiration)}
+	if s.RefreshAfter > 0 {
+		item.Value = stampWritten(value, now())
+		item.Flags |= flagWritten
+	}
+	if s.shouldCompress(len(value)) {
+		if compressed, err := compress(item.Value); err == nil && len(compressed) < len(item.Value) {
+			item.Value = compressed
+			item.Flags |= flagCompressed
+		}
+	}
+	return item
+}
+
+// newEntityItem is newItem for the entity with key, expiring after the KindExpirations of its kind if it has one.
+func (s *Cachestore) newEntityItem(key *datastore.Key, encodedKey string, value []byte) *memcache.Item {
+	item := s.newItem(encodedKey, value)
+	if expiration, ok := s.KindExpirations[key.Kind()]; ok {
+		item.Expiration = s.jitter(expiration)
+	}
+	return item
+}
+
+// splitItems replaces items that are too large for memcache with chunk items, and a manifest item under the original
+// key that lists them.
+func splitItems(items []*memcache.Item) []*memcache.Item {
+	split := make([]*memcache.Item, 0, len(items))
+	for _, item := range items {
+		if len(item.Key)+len(item.Value) <= maxItemSize {
+			split = append(split, item)
+			continue
+		}
+		checksum, n := crc32.ChecksumIEEE(item.Value), 0
+		for value := item.Value; len(value) > 0; n++ {
+			size := chunkSize
+			if len(value) < size {
+				size = len(value)
+			}
+			chunk := &memcache.Item{Key: chunkKey(item.Key, checksum, n), Value: value[:size], Flags: item.Flags & itemFlagsMask, Expiration: item.Expiration}
+			split = append(split, chunk)
+			value = value[size:]
+		}
+		manifest := &memcache.Item{
+			Key:        item.Key,
+			Value:      []byte(fmt.Sprintf("%08x %d", checksum, n)),
+			Flags:      item.Flags | flagChunked,
+			Expiration: item.Expiration,
+		}
+		split = append(split, manifest)
+	}
+	return split
+}
+
+// joinChunks returns the item that was split into the chunks listed by manifest, and whether all the chunks were
+// found and matched the manifest's checksum.
+func joinChunks(manifest *memcache.Item, chunks map[string]*memcache.Item) (*memcache.Item, bool) {
+	checksum, n, ok := parseManifest(manifest)
+	if !ok {
+		return nil, false
+	}
+	value := *new([]byte)
+	for i := 0; i < n; i++ {
+		chunk := chunks[chunkKey(manifest.Key, checksum, i)]
+		if chunk == nil {
+			return nil, false
+		}
+		value = append(value, chunk.Value...)
+	}
+	if crc32.ChecksumIEEE(value) != checksum {
+		return nil, false
+	}
+	return &memcache.Item{Key: manifest.Key, Value: value, Flags: manifest.Flags &^ flagChunked}, true
+}
+
+// parseManifest returns the checksum and number of chunks listed by manifest.
+func parseManifest(manifest *memcache.Item) (checksum uint32, n int, ok bool) {
+	_, err := fmt.Sscanf(string(manifest.Value), "%08x %d", &checksum, &n)
+	return checksum, n, err == nil
+}
+
+// chunkKey returns the key of the ith chunk of the item for key. The checksum keeps readers of an old manifest from
+// mixing chunks of different values.
+func chunkKey(key string, checksum uint32, i int) string {
+	return fmt.Sprintf("%s/%08x/%d", key, checksum, i)
+}
+
+// uncache deletes structs and PropertyLoadSavers from memcache, and calls OnEvict with their keys. Keys that aren't
+// cached are not an error. With MemcacheReadOnly they're only removed from the local caches.
+func (s *Cachestore) uncache(key []*datastore.Key, c context.Context) error {
+	c, cancel := s.withTimeout(c)
+	defer cancel()
+	encodedKeys := s.encodeKeys(key)
+	s.removeLocal(c, encodedKeys)
+	if s.MemcacheReadOnly {
+		return nil
+	}
+	err := s.deleteMulti(c, encodedKeys)
+	if s.OnEvict != nil && len(encodedKeys) > 0 {
+		s.OnEvict(encodedKeys)
+	}
+	if me, ok := err.(appengine.MultiError); ok {
+		any := false
+		for i, e := range me {
+			if e == memcache.ErrCacheMiss {
+				me[i] = nil
+			} else if e != nil {
+				any = true
+			}
+		}
+		if any {
+			return me
+		}
+		return nil
+	}
+	if err == memcache.ErrCacheMiss {
+		return nil
 	}
 	return err
 }
 
-// encodeItems returns an array of memcache.Items for all key/value pair where the key is not incomplete.
-func encodeItems(key []*datastore.Key, src interface{}) ([]*memcache.Item, error) {
+// encodeItems returns an array of memcache.Items for all key/value pair where the key is not incomplete or of an
+// uncached kind, expiring as set by opts.
+func (s *Cachestore) encodeItems(key []*datastore.Key, src interface{}, opts []PutOption) ([]*memcache.Item, error) {
 	v := reflect.ValueOf(src)
 	multiArgType, _ := checkMultiArg(v)
 	items := *new([]*memcache.Item)
 	for i, k := range key {
-		if !k.Incomplete() {
-			elem := v.Index(i)
-			if multiArgType == multiArgTypePropertyLoadSaver || multiArgType == multiArgTypeStruct {
-				elem = elem.Addr()
-			}
-			value, err := encode(elem.Interface())
+		if !k.Incomplete() && !s.uncached(k) {
+			value, err := s.encode(elem(v, i, multiArgType).Interface())
 			if err != nil {
 				return items, err
 			}
-			item := &memcache.Item{Key: k.Encode(), Value: value}
+			item := s.newEntityItem(k, s.encodeKey(k), value)
+			if expiration := putOption(opts, i).Expiration; expiration != 0 {
+				item.Expiration = s.jitter(expiration)
+			}
 			items = append(items, item)
 		}
 	}
 	return items, nil
 }
 
-// encode encodes src using gob.Encoder
-func encode(src interface{}) (b []byte, err error) {
-	c := make(chan datastore.Property, 32)
-	donec := make(chan struct{})
-	go func() {
-		b, err = propertiesToGob(c)
-		close(donec)
-	}()
-	var err1 error
+// elem returns the ith element of v, a slice of type multiArgType, as a struct pointer or PropertyLoadSaver.
+func elem(v reflect.Value, i int, multiArgType multiArgType) reflect.Value {
+	e := v.Index(i)
+	if multiArgType == multiArgTypePropertyLoadSaver || multiArgType == multiArgTypeStruct {
+		e = e.Addr()
+	}
+	return e
+}
+
+// encode encodes src using DefaultCodec
+func (s *Cachestore) encode(src interface{}) ([]byte, error) {
+	properties, err := saveProperties(src)
+	if err != nil {
+		return nil, err
+	}
+	return s.codec().Marshal(properties)
+}
+
+// saveProperties returns the properties of src, a struct pointer or PropertyLoadSaver, with their times normalized.
+func saveProperties(src interface{}) ([]datastore.Property, error) {
+	var properties []datastore.Property
+	var err error
 	if e, ok := src.(datastore.PropertyLoadSaver); ok {
-		err1 = e.Save(c)
+		properties, err = e.Save()
 	} else {
-		err1 = datastore.SaveStruct(src, c)
+		properties, err = datastore.SaveStruct(src)
 	}
-	<-donec
-	if err1 != nil {
-		return nil, err1
+	if err != nil {
+		return nil, err
 	}
-	return b, err
+	return normalizeTimes(properties), nil
 }
 
-func propertiesToGob(src <-chan datastore.Property) ([]byte, error) {
-	defer func() {
-		for _ = range src {
-			// Drain the src channel, if we exit early.
+// normalizeTimes returns properties with their times truncated to microseconds in UTC, like datastore stores them, so
+// that cached entities load the same times as entities read from datastore. properties is only copied if it has times
+// that aren't normalized.
+func normalizeTimes(properties []datastore.Property) []datastore.Property {
+	copied := false
+	for i, p := range properties {
+		t, ok := p.Value.(time.Time)
+		if !ok {
+			continue
+		}
+		if normalized := t.Truncate(time.Microsecond).UTC(); normalized != t {
+			if !copied {
+				properties, copied = append([]datastore.Property(nil), properties...), true
+			}
+			properties[i].Value = normalized
 		}
-	}()
-	properties := *new([]datastore.Property)
-	for p := range src {
-		properties = append(properties, p)
 	}
-	buffer := new(bytes.Buffer)
-	encoder := gob.NewEncoder(buffer)
-	err := encoder.Encode(properties)
-	return buffer.Bytes(), err
+	return properties
+}
+
+// errItemMissing is reported for keys whose item is in items but nil, which memcache never returns.
+var errItemMissing = errors.New("cachestore: memcache item unexpectedly missing")
+
+// ErrCacheDecode is returned when a cached entity can't be decoded, because memcache's value is corrupt or was
+// encoded by an incompatible Codec. GetMulti removes such entities from memcache and reads them from datastore
+// instead, so only functions that read memcache alone return it.
+type ErrCacheDecode struct {
+	Key *datastore.Key
+	Err error
+}
+
+func (e *ErrCacheDecode) Error() string {
+	return fmt.Sprintf("cachestore: decoding cached entity %v: %v", e.Key, e.Err)
 }
 
-// decodeItems decodes items and writes them to dst.
-func decodeItems(key []*datastore.Key, items map[string]*memcache.Item, dst interface{}) error {
+// decodeItems decodes items and writes them to dst. It returns the indexes of the keys that weren't found in items,
+// and the errors that occurred decoding the others. Keys whose item is nil count as not found, with errItemMissing as
+// their error, and so do keys whose item can't be decoded, with an *ErrCacheDecode.
+func (s *Cachestore) decodeItems(key []*datastore.Key, items map[string]*memcache.Item, dst interface{}) ([]int, appengine.MultiError) {
 	v := reflect.ValueOf(dst)
 	multiArgType, _ := checkMultiArg(v)
-	multiErr, any := make(appengine.MultiError, len(key)), false
+	missing, multiErr := *new([]int), make(appengine.MultiError, len(key))
 	for i, k := range key {
-		item := items[k.Encode()]
+		item, ok := items[s.encodeKey(k)]
 		if item == nil {
-			multiErr[i] = datastore.ErrNoSuchEntity
+			if ok {
+				multiErr[i] = errItemMissing
+			}
+			missing = append(missing, i)
 		} else {
-			d := v.Index(i)
-			if multiArgType == multiArgTypePropertyLoadSaver || multiArgType == multiArgTypeStruct {
-				d = d.Addr()
+			multiErr[i] = s.decodeElem(v, i, multiArgType, k, item.Value)
+			if e, ok := multiErr[i].(*ErrCacheDecode); ok {
+				e.Key = k
+				missing = append(missing, i)
 			}
-			multiErr[i] = decode(d.Interface(), item.Value)
 		}
-		if multiErr[i] != nil {
-			any = true
+	}
+	return missing, multiErr
+}
+
+// KeyLoader is implemented by entities that need their key when they're loaded, for example to set an ID field. It's
+// cachestore's own interface, datastore doesn't have one: GetMulti gives entities their key before loading them, or
+// in transactions and WithBypass contexts, where datastore loads them, after.
+type KeyLoader interface {
+	LoadKey(k *datastore.Key) error
+}
+
+// loadKeys gives the entities loaded from datastore at the indexes of v, a slice, their key if they implement KeyLoader.
+// Entities that failed to load, other than with ErrFieldMismatch, are skipped, and LoadKey's errors are set in errs.
+func loadKeys(key []*datastore.Key, v reflect.Value, index []int, errs appengine.MultiError) {
+	multiArgType, _ := checkMultiArg(v)
+	for _, j := range index {
+		if _, mismatch := errs[j].(*datastore.ErrFieldMismatch); errs[j] != nil && !mismatch {
+			continue
+		}
+		if l, ok := elem(v, j, multiArgType).Interface().(KeyLoader); ok {
+			if err := l.LoadKey(key[j]); err != nil {
+				errs[j] = err
+			}
 		}
 	}
-	if any {
-		return multiErr
+}
+
+// decodeElem decodes b, the entity for key, into the ith element of v, a slice of type multiArgType, like loadElem.
+func (s *Cachestore) decodeElem(v reflect.Value, i int, multiArgType multiArgType, key *datastore.Key, b []byte) error {
+	properties, err := s.codec().Unmarshal(b)
+	if err != nil {
+		return &ErrCacheDecode{Err: err}
 	}
-	return nil
+	return s.loadElem(v, i, multiArgType, key, properties)
 }
 
-// decode decodes b into dst using a gob.Decoder
-func decode(dst interface{}, b []byte) (err error) {
-	c := make(chan datastore.Property, 32)
-	errc := make(chan error, 1)
-	defer func() {
-		if err == nil {
-			err = <-errc
+// loadElem loads properties, the entity for key, into the ith element of v, a slice of type multiArgType, allocating
+// it if it's a nil struct pointer. It gives key to elements that implement KeyLoader before loading them.
+func (s *Cachestore) loadElem(v reflect.Value, i int, multiArgType multiArgType, key *datastore.Key, properties []datastore.Property) error {
+	e := elem(v, i, multiArgType)
+	if multiArgType == multiArgTypeStructPtr && e.IsNil() {
+		e.Set(reflect.New(e.Type().Elem()))
+	}
+	if l, ok := e.Interface().(KeyLoader); ok {
+		if err := l.LoadKey(key); err != nil {
+			return err
 		}
-	}()
-	go gobToProperties(c, errc, b)
-	if e, ok := dst.(datastore.PropertyLoadSaver); ok {
-		return e.Load(c)
 	}
-	return datastore.LoadStruct(dst, c)
+	return s.loadProperties(e.Interface(), properties)
 }
 
-func gobToProperties(dst chan<- datastore.Property, errc chan<- error, b []byte) {
-	defer close(dst)
-	var properties []datastore.Property
-	reader := bytes.NewReader(b)
-	decoder := gob.NewDecoder(reader)
-	if err := decoder.Decode(&properties); err != nil {
-		errc <- err
-		return
+// decode decodes b into dst using DefaultCodec
+func (s *Cachestore) decode(dst interface{}, b []byte) error {
+	properties, err := s.codec().Unmarshal(b)
+	if err != nil {
+		return &ErrCacheDecode{Err: err}
 	}
-	for _, p := range properties {
-		// gob encoded key pointers as keys, convert them back to pointers
-		if key, ok := p.Value.(datastore.Key); ok {
-			p.Value = &key
+	return s.loadProperties(dst, properties)
+}
+
+// loadProperties loads properties into dst, a struct pointer or PropertyLoadSaver, ignoring ErrFieldMismatch if
+// IgnoreFieldMismatch is set.
+func (s *Cachestore) loadProperties(dst interface{}, properties []datastore.Property) error {
+	if e, ok := dst.(datastore.PropertyLoadSaver); ok {
+		return e.Load(properties)
+	}
+	err := datastore.LoadStruct(dst, properties)
+	if _, ok := err.(*datastore.ErrFieldMismatch); ok && s.IgnoreFieldMismatch {
+		return nil
+	}
+	return err
+}
+
+// ignoreFieldMismatch clears the ErrFieldMismatch errors at index from errs.
+func ignoreFieldMismatch(errs appengine.MultiError, index []int) {
+	for _, j := range index {
+		if _, ok := errs[j].(*datastore.ErrFieldMismatch); ok {
+			errs[j] = nil
 		}
-		dst <- p
 	}
-	errc <- nil
 }
@@ -0,0 +1,189 @@
+package cachestore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+// GetMemcacheOnly loads the entity cached for key into dst using the default Cachestore. See
+// Cachestore.GetMemcacheOnly.
+func GetMemcacheOnly(c context.Context, key *datastore.Key, dst interface{}) error {
+	return defaultCachestore().GetMemcacheOnly(c, key, dst)
+}
+
+// GetMultiMemcacheOnly is a batch version of GetMemcacheOnly. See Cachestore.GetMultiMemcacheOnly.
+func GetMultiMemcacheOnly(c context.Context, key []*datastore.Key, dst interface{}) error {
+	return defaultCachestore().GetMultiMemcacheOnly(c, key, dst)
+}
+
+// SetCacheOnly caches src for key without writing it to datastore using the default Cachestore. See
+// Cachestore.SetCacheOnly.
+func SetCacheOnly(c context.Context, key *datastore.Key, src interface{}, expiration time.Duration) error {
+	return defaultCachestore().SetCacheOnly(c, key, src, expiration)
+}
+
+// AddCacheOnly caches src for key without writing it to datastore, unless an entity is already cached for key, using
+// the default Cachestore. See Cachestore.AddCacheOnly.
+func AddCacheOnly(c context.Context, key *datastore.Key, src interface{}, expiration time.Duration) error {
+	return defaultCachestore().AddCacheOnly(c, key, src, expiration)
+}
+
+// ErrAlreadyExists is returned by AddCacheOnly when an entity is already cached for its key.
+var ErrAlreadyExists = errors.New("cachestore: entity already cached")
+
+var (
+	errIncompleteKey = errors.New("cachestore: can't cache an entity with an incomplete key")
+	errNotAdded      = errors.New("cachestore: can't cache an entity of an UncachedKinds kind or while MemcacheReadOnly is set")
+)
+
+// GetMemcacheOnly loads the entity cached for key into dst like Get, but only reads memcache: if the entity isn't
+// cached it returns memcache.ErrCacheMiss instead of reading it from datastore. The entity may be stale.
+func (s *Cachestore) GetMemcacheOnly(c context.Context, key *datastore.Key, dst interface{}) error {
+	err := s.GetMultiMemcacheOnly(c, []*datastore.Key{key}, []interface{}{dst})
+	if me, ok := err.(appengine.MultiError); ok {
+		return me[0]
+	}
+	return err
+}
+
+// GetMultiMemcacheOnly is a batch version of GetMemcacheOnly. The errors of the keys that aren't cached are
+// memcache.ErrCacheMiss, and those of the keys whose cached entities can't be decoded are *ErrCacheDecode.
+func (s *Cachestore) GetMultiMemcacheOnly(c context.Context, key []*datastore.Key, dst interface{}) error {
+	if err := checkMultiLen(key, dst); err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return nil
+	}
+	itemMap, _, err := s.getEntityItems(c, s.encodeKeys(key))
+	if err != nil {
+		return err
+	}
+	missing, errs := s.decodeItems(key, itemMap, dst)
+	for _, j := range missing {
+		if _, ok := errs[j].(*ErrCacheDecode); !ok {
+			errs[j] = memcache.ErrCacheMiss
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return errs
+		}
+	}
+	return nil
+}
+
+// SetCacheOnly caches src for key like Put, but without writing it to datastore, for entities that only live in
+// memcache. Read them with GetMemcacheOnly: Get would read datastore once memcache evicts them. src must satisfy the
+// same conditions as Put's src, and key must be complete since there's no datastore to allocate one. The entity
+// expires after expiration, or the Cachestore's Expiration if it's zero. Entities of UncachedKinds kinds can't be
+// cached, nor can any while MemcacheReadOnly is set, so SetCacheOnly fails for them like AddCacheOnly.
+func (s *Cachestore) SetCacheOnly(c context.Context, key *datastore.Key, src interface{}, expiration time.Duration) error {
+	if err := checkCacheOnly(key, src); err != nil {
+		return err
+	}
+	if s.MemcacheReadOnly || s.uncached(key) {
+		return errNotAdded
+	}
+	keys := []*datastore.Key{key}
+	s.removeLocal(c, s.encodeKeys(keys))
+	err := s.cache(keys, []interface{}{src}, []PutOption{{Expiration: expiration}}, c)
+	if me, ok := err.(appengine.MultiError); ok {
+		return me[0]
+	}
+	return err
+}
+
+// AddCacheOnly caches src for key like SetCacheOnly, but only if no entity is cached for key, returning
+// ErrAlreadyExists otherwise. Memcache adds items atomically, so of concurrent AddCacheOnly calls for the same key at
+// most one succeeds, which makes it a simple lock or once-only marker keyed on datastore keys. It is best-effort
+// though: memcache may evict the entity before it expires, after which AddCacheOnly succeeds again. Items that reads
+// ignore, like the locks of reads in progress and items of other Versions or cached before Flush, don't count as
+// cached entities and are replaced.
+func (s *Cachestore) AddCacheOnly(c context.Context, key *datastore.Key, src interface{}, expiration time.Duration) error {
+	if err := checkCacheOnly(key, src); err != nil {
+		return err
+	}
+	keys := []*datastore.Key{key}
+	items, err := s.encodeItems(keys, []interface{}{src}, []PutOption{{Expiration: expiration}})
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 || s.MemcacheReadOnly {
+		return errNotAdded
+	}
+	generation, err := s.getGeneration(c)
+	if err != nil {
+		return err
+	}
+	c, cancel := s.withTimeout(c)
+	defer cancel()
+	// write the chunks of a large entity first, only adding the item under key decides whether it's cached
+	split := splitItems(tagItems(items, generation))
+	chunks, item := split[:len(split)-1], split[len(split)-1]
+	if len(chunks) > 0 {
+		if err := s.setMulti(c, s.backend().SetMulti, chunks); err != nil {
+			return err
+		}
+	}
+	err = s.addItem(c, item)
+	if err == nil {
+		s.removeLocal(c, s.encodeKeys(keys))
+	}
+	return err
+}
+
+// addItem adds item to memcache, replacing the item already under its key if reads would ignore it. It returns
+// ErrAlreadyExists if there's an entity under the key, or if another call wrote one while the item was replaced.
+func (s *Cachestore) addItem(c context.Context, item *memcache.Item) error {
+	err := singleError(s.setMulti(c, s.backend().AddMulti, []*memcache.Item{item}))
+	if err != memcache.ErrNotStored {
+		return err
+	}
+	cached, _, err := s.getItems(c, []string{item.Key})
+	if err != nil {
+		return err
+	}
+	if cached[item.Key] != nil {
+		return ErrAlreadyExists
+	}
+	current, err := s.getMulti(c, []string{item.Key})
+	if err != nil {
+		return err
+	}
+	set := s.backend().AddMulti
+	if ignored := current[item.Key]; ignored != nil {
+		ignored.Value, ignored.Flags, ignored.Expiration = item.Value, item.Flags, item.Expiration
+		item, set = ignored, s.backend().CompareAndSwapMulti
+	}
+	err = singleError(s.setMulti(c, set, []*memcache.Item{item}))
+	if err == memcache.ErrNotStored || err == memcache.ErrCASConflict {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+// checkCacheOnly returns the error of caching src for key without datastore, if it can't be.
+func checkCacheOnly(key *datastore.Key, src interface{}) error {
+	if key.Incomplete() {
+		return errIncompleteKey
+	}
+	if !isEntity(reflect.ValueOf(src)) {
+		return datastore.ErrInvalidEntityType
+	}
+	return nil
+}
+
+// singleError returns the error of the only item of a memcache call that returned err.
+func singleError(err error) error {
+	if me, ok := err.(appengine.MultiError); ok {
+		return me[0]
+	}
+	return err
+}
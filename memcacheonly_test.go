@@ -0,0 +1,171 @@
+package cachestore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+func TestGetMemcacheOnly(t *testing.T) {
+	requireAetest(t)
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cache the first
+	err = Get(c, key[0], &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMultiMemcacheOnly without reading datastore
+	noDatastore := appengine.WithAPICallFunc(c, func(ctx context.Context, s, m string, in, out proto.Message) error {
+		if s == "datastore_v3" {
+			t.Fatalf("unexpected datastore call %s", m)
+		}
+		return appengine.APICall(ctx, s, m, in, out)
+	})
+	dst := make([]Struct, len(src))
+	err = GetMultiMemcacheOnly(noDatastore, key, dst)
+	expected := appengine.MultiError{nil, memcache.ErrCacheMiss}
+	if !reflect.DeepEqual(expected, err) {
+		t.Fatalf("expected=%#v actual=%#v", expected, err)
+	}
+	if dst[0] != src[0] {
+		t.Fatalf("expected=%#v actual=%#v", src[0], dst[0])
+	}
+	// GetMemcacheOnly
+	err = GetMemcacheOnly(noDatastore, key[1], &Struct{})
+	if err != memcache.ErrCacheMiss {
+		t.Fatalf("expected=%#v actual=%#v", memcache.ErrCacheMiss, err)
+	}
+	// still not cached
+	err = GetMemcacheOnly(noDatastore, key[1], &Struct{})
+	if err != memcache.ErrCacheMiss {
+		t.Fatalf("expected=%#v actual=%#v", memcache.ErrCacheMiss, err)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetCacheOnly(t *testing.T) {
+	requireAetest(t)
+	noDatastore := appengine.WithAPICallFunc(c, func(ctx context.Context, s, m string, in, out proto.Message) error {
+		if s == "datastore_v3" {
+			t.Fatalf("unexpected datastore call %s", m)
+		}
+		return appengine.APICall(ctx, s, m, in, out)
+	})
+	// SetCacheOnly with an incomplete key
+	err := SetCacheOnly(noDatastore, datastore.NewIncompleteKey(c, "Session", nil), &Struct{}, 0)
+	if err != errIncompleteKey {
+		t.Fatalf("expected=%#v actual=%#v", errIncompleteKey, err)
+	}
+	// SetCacheOnly
+	src := &Struct{I: 5}
+	key := datastore.NewKey(c, "Session", "session", 0, nil)
+	err = SetCacheOnly(noDatastore, key, src, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMemcacheOnly
+	dst := &Struct{}
+	err = GetMemcacheOnly(noDatastore, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// expired
+	time.Sleep(2 * time.Second)
+	err = GetMemcacheOnly(noDatastore, key, &Struct{})
+	if err != memcache.ErrCacheMiss {
+		t.Fatalf("expected=%#v actual=%#v", memcache.ErrCacheMiss, err)
+	}
+}
+
+func TestAddCacheOnly(t *testing.T) {
+	requireAetest(t)
+	key := datastore.NewKey(c, "Lease", "addCacheOnly", 0, nil)
+	// AddCacheOnly
+	src := &Struct{I: 1}
+	err := AddCacheOnly(c, key, src, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// AddCacheOnly again
+	err = AddCacheOnly(c, key, &Struct{I: 2}, time.Minute)
+	if err != ErrAlreadyExists {
+		t.Fatalf("expected=%#v actual=%#v", ErrAlreadyExists, err)
+	}
+	dst := &Struct{}
+	err = GetMemcacheOnly(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// AddCacheOnly after Flush replaces the flushed item
+	err = Flush(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src = &Struct{I: 3}
+	err = AddCacheOnly(c, key, src, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = GetMemcacheOnly(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddCacheOnlyConcurrently(t *testing.T) {
+	requireAetest(t)
+	key := datastore.NewKey(c, "Lease", "addCacheOnlyConcurrently", 0, nil)
+	// AddCacheOnly from several goroutines
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		go func(i int) {
+			errs <- AddCacheOnly(c, key, &Struct{I: i}, time.Minute)
+		}(i)
+	}
+	added := 0
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err == nil {
+			added++
+		} else if err != ErrAlreadyExists {
+			t.Fatal(err)
+		}
+	}
+	if added != 1 {
+		t.Fatalf("expected=%#v actual=%#v", 1, added)
+	}
+	// Delete
+	err := Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
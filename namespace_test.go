@@ -0,0 +1,172 @@
+package cachestore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+func TestNamespaces(t *testing.T) {
+	requireAetest(t)
+	tenant1, err := appengine.Namespace(c, "tenant1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenant2, err := appengine.Namespace(c, "tenant2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Put the same logical key in each namespace
+	key1, key2 := datastore.NewKey(tenant1, "Struct", "tenant", 0, nil), datastore.NewKey(tenant2, "Struct", "tenant", 0, nil)
+	src1, src2 := &Struct{I: 1}, &Struct{I: 2}
+	if _, err = Put(tenant1, key1, src1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Put(tenant2, key2, src2); err != nil {
+		t.Fatal(err)
+	}
+	// Get from datastore, then from memcache
+	for i := 0; i < 2; i++ {
+		dst := &Struct{}
+		if err = Get(tenant1, key1, dst); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src1, dst) {
+			t.Fatalf("expected=%#v actual=%#v", src1, dst)
+		}
+		dst = &Struct{}
+		if err = Get(tenant2, key2, dst); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src2, dst) {
+			t.Fatalf("expected=%#v actual=%#v", src2, dst)
+		}
+	}
+	// Get tenant1's key with another namespace's context, then Put it with its own
+	if err = Get(tenant2, key1, &Struct{}); err != nil {
+		t.Fatal(err)
+	}
+	src1 = &Struct{I: 3}
+	if _, err = Put(tenant1, key1, src1); err != nil {
+		t.Fatal(err)
+	}
+	dst := &Struct{}
+	if err = Get(tenant2, key1, dst); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src1, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src1, dst)
+	}
+	// DeleteMulti
+	if err = Delete(tenant1, key1); err != nil {
+		t.Fatal(err)
+	}
+	if err = Delete(tenant2, key2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemcacheNamespace(t *testing.T) {
+	requireAetest(t)
+	MemcacheNamespace = "cs"
+	defer func() { MemcacheNamespace = "" }()
+	tenant, err := appengine.Namespace(c, "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := appengine.Namespace(c, "cs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &Struct{I: 1}
+	// Put in the context's namespace
+	key, err := Put(tenant, datastore.NewIncompleteKey(tenant, "Struct", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Namespace() != "tenant" {
+		t.Fatalf("expected=%#v actual=%#v", "tenant", key.Namespace())
+	}
+	// Get from datastore into memcache
+	err = Get(tenant, key, &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the entity is cached in MemcacheNamespace only
+	encodedKey := New().encodeKey(key)
+	if _, err = memcache.Get(cs, encodedKey); err != nil {
+		t.Fatal(err)
+	}
+	for _, ns := range []context.Context{c, tenant} {
+		if _, err = memcache.Get(ns, encodedKey); err != memcache.ErrCacheMiss {
+			t.Fatalf("expected=%#v actual=%#v", memcache.ErrCacheMiss, err)
+		}
+	}
+	// Get from memcache
+	before := Stats()
+	dst := &Struct{}
+	err = Get(tenant, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	if reads := Stats().DatastoreReads - before.DatastoreReads; reads != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, reads)
+	}
+	// Delete from both
+	err = Delete(tenant, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = memcache.Get(cs, encodedKey); err != memcache.ErrCacheMiss {
+		t.Fatalf("expected=%#v actual=%#v", memcache.ErrCacheMiss, err)
+	}
+	if err = datastore.Get(tenant, key, &Struct{}); err != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+}
+
+func TestGetOrLoadNamespaces(t *testing.T) {
+	requireAetest(t)
+	tenant1, err := appengine.Namespace(c, "tenant1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenant2, err := appengine.Namespace(c, "tenant2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetOrLoad the same cache key in each namespace
+	for _, test := range []struct {
+		c   context.Context
+		src *Struct
+	}{{tenant1, &Struct{I: 1}}, {tenant2, &Struct{I: 2}}, {tenant1, &Struct{I: 1}}} {
+		dst := &Struct{}
+		err = GetOrLoad(test.c, "namespaced", dst, time.Minute, func() (interface{}, error) { return test.src, nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(test.src, dst) {
+			t.Fatalf("expected=%#v actual=%#v", test.src, dst)
+		}
+	}
+	// loaded once per namespace
+	dst := &Struct{}
+	err = GetOrLoad(tenant2, "namespaced", dst, time.Minute, func() (interface{}, error) {
+		t.Fatal("unexpected load")
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (&Struct{I: 2}); !reflect.DeepEqual(expected, dst) {
+		t.Fatalf("expected=%#v actual=%#v", expected, dst)
+	}
+}
@@ -0,0 +1,43 @@
+package cachestore
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestOnEvict(t *testing.T) {
+	requireAetest(t)
+	var evicted [][]string
+	OnEvict = func(keys []string) { evicted = append(evicted, keys) }
+	defer func() { OnEvict = nil }()
+	key := []*datastore.Key{datastore.NewKey(c, "Struct", "onEvict1", 0, nil), datastore.NewKey(c, "Struct", "onEvict2", 0, nil)}
+	expected := [][]string{defaultCachestore().encodeKeys(key)}
+	// PutMulti
+	_, err := PutMulti(c, key, []Struct{{1}, {2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, evicted) {
+		t.Fatalf("expected=%#v actual=%#v", expected, evicted)
+	}
+	// DeleteMulti with memcache failing
+	evicted = nil
+	err = DeleteMulti(failingContext("memcache", "Delete"), key)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !reflect.DeepEqual(expected, evicted) {
+		t.Fatalf("expected=%#v actual=%#v", expected, evicted)
+	}
+	// DeleteMulti
+	evicted = nil
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, evicted) {
+		t.Fatalf("expected=%#v actual=%#v", expected, evicted)
+	}
+}
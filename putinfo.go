@@ -0,0 +1,28 @@
+package cachestore
+
+import (
+	"context"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// PutMultiWithInfo is PutMulti using the default Cachestore, also returning which keys datastore allocated. See
+// Cachestore.PutMultiWithInfo.
+func PutMultiWithInfo(c context.Context, key []*datastore.Key, src interface{}) ([]*datastore.Key, []bool, error) {
+	return defaultCachestore().PutMultiWithInfo(c, key, src)
+}
+
+// PutMultiWithInfo is PutMulti, also returning whether each returned key was allocated by datastore for an incomplete
+// key, rather than passed in complete, for example to index the IDs of new entities. If a batch fails its incomplete
+// keys are returned as they were passed in, and aren't reported as allocated.
+func (s *Cachestore) PutMultiWithInfo(c context.Context, key []*datastore.Key, src interface{}) ([]*datastore.Key, []bool, error) {
+	putKey, err := s.putEntities(c, key, src, nil)
+	if putKey == nil {
+		return nil, nil, err
+	}
+	allocated := make([]bool, len(key))
+	for i, k := range key {
+		allocated[i] = k.Incomplete() && putKey[i] != nil && !putKey[i].Incomplete()
+	}
+	return putKey, allocated, err
+}
@@ -0,0 +1,44 @@
+package cachestore
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestPutMultiWithInfo(t *testing.T) {
+	requireAetest(t)
+	src := []Struct{{1}, {2}, {3}}
+	key := []*datastore.Key{
+		datastore.NewIncompleteKey(c, "Struct", nil),
+		datastore.NewKey(c, "Struct", "putMultiWithInfo", 0, nil),
+		datastore.NewIncompleteKey(c, "Struct", nil),
+	}
+	// PutMultiWithInfo
+	putKey, allocated, err := PutMultiWithInfo(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []bool{true, false, true}
+	if !reflect.DeepEqual(expected, allocated) {
+		t.Fatalf("expected=%#v actual=%#v", expected, allocated)
+	}
+	if !putKey[1].Equal(key[1]) {
+		t.Fatalf("expected=%#v actual=%#v", key[1], putKey[1])
+	}
+	// PutMultiWithInfo again with the returned keys
+	_, allocated, err = PutMultiWithInfo(c, putKey, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []bool{false, false, false}
+	if !reflect.DeepEqual(expected, allocated) {
+		t.Fatalf("expected=%#v actual=%#v", expected, allocated)
+	}
+	// Delete
+	err = DeleteMulti(c, putKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
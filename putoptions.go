@@ -0,0 +1,70 @@
+package cachestore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// PutOption sets how PutMultiWithOptions caches an entity.
+type PutOption struct {
+	Expiration time.Duration // Expiration of the cached entity, zero means its kind's KindExpirations or Expiration
+
+	// NoEvict, if true, skips removing the entity from memcache after writing it, for bulk imports of entities that
+	// aren't cached, which would otherwise call memcache to remove each of them. If the entity was cached in memcache,
+	// the cached entity is stale until it expires, but it's still removed from LocalCache and the request cache. With
+	// WriteThrough the entity is still cached, but not removed from memcache if caching fails.
+	NoEvict bool
+}
+
+var errPutOptionsLength = errors.New("cachestore: put options must be one per key, or one for all keys")
+
+// PutMultiWithOptions is PutMulti using the default Cachestore, caching each entity with its option. See
+// Cachestore.PutMultiWithOptions.
+func PutMultiWithOptions(c context.Context, key []*datastore.Key, src interface{}, opts []PutOption) ([]*datastore.Key, error) {
+	return defaultCachestore().PutMultiWithOptions(c, key, src, opts)
+}
+
+// PutMultiWithOptions is PutMulti, writing the entity for key[i] with opts[i], or each entity with opts[0] if opts
+// has a single element. With WriteThrough, each entity is cached with its option's Expiration, and NoEvict only
+// keeps it in memcache if caching it fails. Without WriteThrough, entities aren't cached on Put so Expiration has no
+// effect, and NoEvict skips removing the entity from memcache.
+func (s *Cachestore) PutMultiWithOptions(c context.Context, key []*datastore.Key, src interface{}, opts []PutOption) ([]*datastore.Key, error) {
+	if len(opts) != 1 && len(opts) != len(key) {
+		return nil, errPutOptionsLength
+	}
+	return s.putEntities(c, key, src, opts)
+}
+
+// evictPut removes the entities written for key from memcache like evict, except the ones whose option is NoEvict,
+// which are only removed from the local caches since those don't expire.
+func (s *Cachestore) evictPut(c context.Context, key []*datastore.Key, opts []PutOption) error {
+	evicted, evictedKey, kept := *new([]int), *new([]*datastore.Key), *new([]*datastore.Key)
+	for i, k := range key {
+		if putOption(opts, i).NoEvict {
+			kept = append(kept, k)
+		} else {
+			evicted, evictedKey = append(evicted, i), append(evictedKey, k)
+		}
+	}
+	if len(kept) > 0 {
+		s.removeLocal(c, s.encodeKeys(kept))
+	}
+	if len(evicted) == 0 {
+		return nil
+	}
+	return remapErrors(s.evict(c, evictedKey), evicted, len(key))
+}
+
+// putOption returns the option of the ith entity in opts, which may be nil.
+func putOption(opts []PutOption, i int) PutOption {
+	switch len(opts) {
+	case 0:
+		return PutOption{}
+	case 1:
+		return opts[0]
+	}
+	return opts[i]
+}
@@ -0,0 +1,197 @@
+package cachestore
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestPutMultiWithOptions(t *testing.T) {
+	requireAetest(t)
+	WriteThrough = true
+	defer func() { WriteThrough = false }()
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMultiWithOptions with mismatched options
+	_, err := PutMultiWithOptions(c, key, src, make([]PutOption, 3))
+	if err != errPutOptionsLength {
+		t.Fatalf("expected=%#v actual=%#v", errPutOptionsLength, err)
+	}
+	// PutMultiWithOptions
+	key, err = PutMultiWithOptions(c, key, src, []PutOption{{Expiration: time.Second}, {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodedKeys := defaultCachestore().encodeKeys(key)
+	items, _, err := defaultCachestore().getItems(c, encodedKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("expected=%#v actual=%#v", 2, len(items))
+	}
+	// only the first expires
+	time.Sleep(2 * time.Second)
+	items, _, err = defaultCachestore().getItems(c, encodedKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := items[encodedKeys[0]]; ok {
+		t.Fatalf("expected %v to expire", key[0])
+	}
+	if _, ok := items[encodedKeys[1]]; !ok {
+		t.Fatalf("expected %v to be cached", key[1])
+	}
+	// PutMultiWithOptions with one option for all keys
+	key, err = PutMultiWithOptions(c, key, src, []PutOption{{Expiration: time.Second}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Second)
+	items, _, err = defaultCachestore().getItems(c, encodedKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, len(items))
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKindExpirations(t *testing.T) {
+	s := &Cachestore{Expiration: time.Hour, KindExpirations: map[string]time.Duration{"Session": time.Minute, "Config": 0}}
+	key := []*datastore.Key{
+		datastore.NewKey(c, "Struct", "kindExpirations", 0, nil),
+		datastore.NewKey(c, "Session", "kindExpirations", 0, nil),
+		datastore.NewKey(c, "Config", "kindExpirations", 0, nil),
+	}
+	src := make([]Struct, len(key))
+	tests := []struct {
+		opts     []PutOption
+		expected []time.Duration
+	}{
+		{nil, []time.Duration{time.Hour, time.Minute, 0}},
+		{[]PutOption{{Expiration: time.Second}, {Expiration: time.Second}, {}}, []time.Duration{time.Second, time.Second, 0}},
+	}
+	for _, test := range tests {
+		items, err := s.encodeItems(key, src, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, item := range items {
+			if item.Expiration != test.expected[i] {
+				t.Fatalf("%v: expected=%#v actual=%#v", key[i], test.expected[i], item.Expiration)
+			}
+		}
+	}
+}
+
+func TestKindExpirationsOfEntitiesReadFromDatastore(t *testing.T) {
+	requireAetest(t)
+	KindExpirations = map[string]time.Duration{"Session": time.Second}
+	defer func() { KindExpirations = nil }()
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Session", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, []Struct{{1}, {2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti from datastore caches them
+	err = GetMulti(c, key, make([]Struct, len(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the Session expires
+	time.Sleep(2 * time.Second)
+	encodedKeys := defaultCachestore().encodeKeys(key)
+	items, _, err := defaultCachestore().getItems(c, encodedKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := items[encodedKeys[0]]; !ok {
+		t.Fatalf("expected %v to be cached", key[0])
+	}
+	if _, ok := items[encodedKeys[1]]; ok {
+		t.Fatalf("expected %v to expire", key[1])
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPutMultiWithNoEvict(t *testing.T) {
+	requireAetest(t)
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMultiWithOptions without evicting
+	defer func(m MemcacheBackend) { memcacheBackend = m }(memcacheBackend)
+	var writes int32
+	memcacheBackend = writeCountingMemcache{memcacheBackend, &writes}
+	key, err := PutMultiWithOptions(c, key, src, []PutOption{{NoEvict: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writes != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, writes)
+	}
+	// load memcache with GetMulti
+	err = GetMulti(c, key, make([]Struct, len(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// PutMultiWithOptions evicting the second
+	updated := []Struct{{3}, {4}}
+	_, err = PutMultiWithOptions(c, key, updated, []PutOption{{NoEvict: true}, {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := make([]Struct, len(key))
+	err = GetMulti(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []Struct{src[0], updated[1]}; dst[0] != expected[0] || dst[1] != expected[1] {
+		t.Fatalf("expected=%#v actual=%#v", expected, dst)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPutMultiWithNoEvictRemovesLocally(t *testing.T) {
+	requireAetest(t)
+	LocalCache = NewLRU(10)
+	defer func() { LocalCache = nil }()
+	// Put
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), &Struct{I: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load the local cache with Get
+	err = Get(c, key, &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// PutMultiWithOptions without evicting
+	_, err = PutMultiWithOptions(c, []*datastore.Key{key}, []Struct{{2}}, []PutOption{{NoEvict: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := LocalCache.get(defaultCachestore().encodeKey(key), 0); ok {
+		t.Fatal("expected PutMultiWithOptions to remove the entity from the local cache")
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,225 @@
+package cachestore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"reflect"
+	"strconv"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+// GetAll runs the query q using the default Cachestore. See Cachestore.GetAll.
+func GetAll(c context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error) {
+	return defaultCachestore().GetAll(c, q, dst)
+}
+
+// GetAll runs the query q and returns the keys of the matching entities, appending the entities to dst like
+// datastore.Query.GetAll. dst must be nil for keys-only queries.
+//
+// The matching keys are cached in memcache for QueryExpiration. The entities aren't cached from the query's results,
+// which may be stale and could overwrite a concurrent Put's: GetAll reads the entities of cached keys with GetMulti,
+// which caches them. Results are eventually consistent: until the cached keys expire, GetAll won't see entities that
+// started or stopped matching q, though it will see changes made through Put or Delete to the entities it does
+// return. Projection and distinct queries, whose results are partial entities, aren't cached. In a RunInTransaction
+// transaction GetAll runs q on datastore directly and caches nothing, like Get.
+func (s *Cachestore) GetAll(c context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error) {
+	var dv reflect.Value
+	if dst != nil {
+		dv = reflect.ValueOf(dst)
+		if dv.Kind() != reflect.Ptr || dv.IsNil() {
+			return nil, datastore.ErrInvalidEntityType
+		}
+		dv = dv.Elem()
+	}
+	if isBypass(c) || transactionFromContext(c) != nil || isProjection(q) {
+		// nothing is cached in transactions, which may not commit, and the entities of keys can't stand for projections
+		return datastoreBackend.GetAll(c, q, dst)
+	}
+	queryKey := s.encodeQuery(c, q)
+	// check cache
+	if key, ok := s.getQueryKeys(c, queryKey); ok {
+		if dst == nil {
+			return key, nil
+		}
+		entities := reflect.MakeSlice(dv.Type(), len(key), len(key))
+		if err := s.GetMulti(c, key, entities.Interface()); err == nil {
+			dv.Set(reflect.AppendSlice(dv, entities))
+			return key, nil
+		}
+		// some of the entities were deleted or can't be loaded, run the query again
+	}
+	// run query
+	key, err := datastoreBackend.GetAll(c, q, dst)
+	if err != nil {
+		return key, err
+	}
+	if s.readOnly(c) {
+		return key, nil
+	}
+	// cache the keys for next time
+	if err := s.setQueryKeys(c, queryKey, key); err != nil {
+		s.debugf(c, "caching query: %v", err)
+	}
+	return key, nil
+}
+
+// isProjection returns whether q is a projection or distinct query. datastore.Query doesn't export its fields, so
+// they're read by reflection like in querySignature.
+func isProjection(q *datastore.Query) bool {
+	v := reflect.ValueOf(q).Elem()
+	if projection := v.FieldByName("projection"); projection.IsValid() && projection.Len() > 0 {
+		return true
+	}
+	distinct := v.FieldByName("distinct")
+	return distinct.IsValid() && distinct.Bool()
+}
+
+// getQueryKeys returns the keys cached for the query with the given memcache key, and whether they were found.
+func (s *Cachestore) getQueryKeys(c context.Context, queryKey string) ([]*datastore.Key, bool) {
+	items, _, err := s.getItems(c, []string{queryKey})
+	item := items[queryKey]
+	if err != nil || item == nil {
+		return nil, false
+	}
+	properties, err := s.codec().Unmarshal(item.Value)
+	if err != nil {
+		return nil, false
+	}
+	key := make([]*datastore.Key, len(properties))
+	for i, p := range properties {
+		k, ok := p.Value.(*datastore.Key)
+		if !ok {
+			return nil, false
+		}
+		key[i] = k
+	}
+	return key, true
+}
+
+// setQueryKeys caches the keys matched by the query with the given memcache key.
+func (s *Cachestore) setQueryKeys(c context.Context, queryKey string, key []*datastore.Key) error {
+	properties := make([]datastore.Property, len(key))
+	for i, k := range key {
+		properties[i] = datastore.Property{Name: "Key", Value: k, Multiple: true}
+	}
+	value, err := s.codec().Marshal(properties)
+	if err != nil {
+		return err
+	}
+	generation, err := s.getGeneration(c)
+	if err != nil {
+		return err
+	}
+	item := s.newItem(queryKey, value)
+	item.Expiration = s.QueryExpiration
+	return s.setItems(c, []*memcache.Item{item}, generation)
+}
+
+// CachedCount returns the number of entities matched by q, caching it for ttl, using the default Cachestore. See
+// Cachestore.CachedCount.
+func CachedCount(c context.Context, q *datastore.Query, ttl time.Duration) (int, error) {
+	return defaultCachestore().CachedCount(c, q, ttl)
+}
+
+// cachedCount is the value CachedCount caches.
+type cachedCount struct {
+	N int64
+}
+
+// CachedCount returns the number of entities matched by q, like q.Count, caching it for ttl (zero means no
+// expiration). Counts are cached per query and namespace like GetAll's results, but writes don't invalidate them, so
+// a count is up to ttl old. Reads with a ReadOnly context don't cache the counts they compute, and failing to cache a
+// count is logged rather than returned.
+func (s *Cachestore) CachedCount(c context.Context, q *datastore.Query, ttl time.Duration) (int, error) {
+	if isBypass(c) || transactionFromContext(c) != nil {
+		return datastoreBackend.Count(c, q)
+	}
+	key := s.KeyPrefix + "count:" + querySignature(c, q)
+	// check cache
+	items, generation, _ := s.getItems(c, []string{key})
+	if item := items[key]; item != nil {
+		var count cachedCount
+		if err := s.decode(&count, item.Value); err == nil {
+			return int(count.N), nil
+		}
+	}
+	// count
+	n, err := datastoreBackend.Count(c, q)
+	if err != nil || s.readOnly(c) {
+		return n, err
+	}
+	// cache for next time
+	value, err := s.encode(&cachedCount{int64(n)})
+	if err == nil {
+		item := s.newItem(key, value)
+		item.Expiration = ttl
+		err = s.setItems(c, []*memcache.Item{item}, generation)
+	}
+	if err != nil {
+		s.debugf(c, "caching count: %v", err)
+	}
+	return n, nil
+}
+
+// encodeQuery returns the memcache key for q's results in the namespace of c.
+func (s *Cachestore) encodeQuery(c context.Context, q *datastore.Query) string {
+	return s.KeyPrefix + "query:" + querySignature(c, q)
+}
+
+// querySignature returns a hash of q in the namespace of c. datastore.Query doesn't export its fields, so its
+// signature is built by reflection.
+func querySignature(c context.Context, q *datastore.Query) string {
+	signature := new(bytes.Buffer)
+	// queries run in the namespace of their context
+	signature.WriteString(datastore.NewKey(c, "", "", 0, nil).Namespace())
+	signature.WriteByte(0)
+	writeSignature(signature, reflect.ValueOf(q))
+	sum := sha1.Sum(signature.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// writeSignature writes a description of v, including its unexported fields, to b.
+func writeSignature(b *bytes.Buffer, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			b.WriteString("nil")
+		} else {
+			writeSignature(b, v.Elem())
+		}
+	case reflect.Struct:
+		b.WriteString(v.Type().String())
+		b.WriteByte('{')
+		for i := 0; i < v.NumField(); i++ {
+			b.WriteString(v.Type().Field(i).Name)
+			b.WriteByte(':')
+			writeSignature(b, v.Field(i))
+			b.WriteByte(',')
+		}
+		b.WriteByte('}')
+	case reflect.Slice, reflect.Array:
+		b.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			writeSignature(b, v.Index(i))
+			b.WriteByte(',')
+		}
+		b.WriteByte(']')
+	case reflect.String:
+		b.WriteString(strconv.Quote(v.String()))
+	case reflect.Bool:
+		b.WriteString(strconv.FormatBool(v.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		b.WriteString(strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		b.WriteString(strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		b.WriteString(strconv.FormatFloat(v.Float(), 'g', -1, 64))
+	default:
+		b.WriteString(v.Kind().String())
+	}
+}
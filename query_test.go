@@ -0,0 +1,203 @@
+package cachestore
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+type Widget struct {
+	Name   string
+	Active bool
+}
+
+func TestGetAll(t *testing.T) {
+	requireAetest(t)
+	QueryExpiration = time.Second
+	defer func() { QueryExpiration = time.Minute }()
+	src := []Widget{{"a", true}, {"b", true}, {"c", false}}
+	key := make([]*datastore.Key, len(src))
+	for i := range src {
+		key[i] = datastore.NewIncompleteKey(c, "Widget", nil)
+	}
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := datastore.NewQuery("Widget").Filter("Active =", true).Order("Name")
+	expected := []Widget{{"a", true}, {"b", true}}
+	// miss
+	dst := *new([]Widget)
+	k, err := GetAll(c, q, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, dst) || len(k) != len(expected) {
+		t.Fatalf("expected=%#v actual=%#v", expected, dst)
+	}
+	// add a matching entity bypassing the cache
+	extra, err := datastore.Put(c, datastore.NewIncompleteKey(c, "Widget", nil), &Widget{"d", true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// hit
+	dst = *new([]Widget)
+	_, err = GetAll(c, datastore.NewQuery("Widget").Filter("Active =", true).Order("Name"), &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, dst) {
+		t.Fatalf("expected=%#v actual=%#v", expected, dst)
+	}
+	// keys-only query is cached separately
+	k, err = GetAll(c, q.KeysOnly(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k) != 3 {
+		t.Fatalf("expected=3 keys actual=%d", len(k))
+	}
+	// expiry
+	time.Sleep(2 * QueryExpiration)
+	dst = *new([]Widget)
+	_, err = GetAll(c, q, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected, Widget{"d", true})
+	if !reflect.DeepEqual(expected, dst) {
+		t.Fatalf("expected=%#v actual=%#v", expected, dst)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, append(key, extra))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetAllCachesEntitiesThroughGetMulti(t *testing.T) {
+	requireAetest(t)
+	key, err := Put(c, datastore.NewIncompleteKey(c, "CachedWidget", nil), &Widget{"a", true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := datastore.NewQuery("CachedWidget")
+	// the query's results aren't cached, then the cached keys' entities are cached by GetMulti
+	for _, expected := range []bool{false, true} {
+		_, err = GetAll(c, q, &[]Widget{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cached, err := Cached(c, []*datastore.Key{key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cached[0] != expected {
+			t.Fatalf("expected=%#v actual=%#v", expected, cached[0])
+		}
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetAllDoesNotCacheProjections(t *testing.T) {
+	requireAetest(t)
+	key, err := Put(c, datastore.NewIncompleteKey(c, "ProjectedWidget", nil), &Widget{"a", true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []*datastore.Query{datastore.NewQuery("ProjectedWidget").Project("Name"), datastore.NewQuery("ProjectedWidget").Project("Name").Distinct()} {
+		if !isProjection(q) {
+			t.Fatalf("expected=%#v actual=%#v", true, false)
+		}
+		for i := 0; i < 2; i++ {
+			_, err = GetAll(c, q, &[]Widget{})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		cached, err := Cached(c, []*datastore.Key{key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cached[0] {
+			t.Fatalf("expected=%#v actual=%#v", false, cached[0])
+		}
+		if _, ok := defaultCachestore().getQueryKeys(c, defaultCachestore().encodeQuery(c, q)); ok {
+			t.Fatalf("expected=%#v actual=%#v", false, ok)
+		}
+	}
+	if isProjection(datastore.NewQuery("ProjectedWidget")) {
+		t.Fatalf("expected=%#v actual=%#v", false, true)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncodeQuery(t *testing.T) {
+	q := datastore.NewQuery("Widget").Filter("Active =", true)
+	if defaultCachestore().encodeQuery(c, q) != defaultCachestore().encodeQuery(c, datastore.NewQuery("Widget").Filter("Active =", true)) {
+		t.Fatal("expected equal queries to have equal keys")
+	}
+	if defaultCachestore().encodeQuery(c, q) == defaultCachestore().encodeQuery(c, q.Filter("Active =", false)) {
+		t.Fatal("expected different filters to have different keys")
+	}
+	if defaultCachestore().encodeQuery(c, q) == defaultCachestore().encodeQuery(c, q.Limit(20)) {
+		t.Fatal("expected different limits to have different keys")
+	}
+	parent := datastore.NewKey(c, "Parent", "p", 0, nil)
+	if defaultCachestore().encodeQuery(c, q.Ancestor(parent)) != defaultCachestore().encodeQuery(c, q.Ancestor(datastore.NewKey(c, "Parent", "p", 0, nil))) {
+		t.Fatal("expected equal ancestors to have equal keys")
+	}
+	tenant, err := appengine.Namespace(c, "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaultCachestore().encodeQuery(c, q) == defaultCachestore().encodeQuery(tenant, q) {
+		t.Fatal("expected different namespaces to have different keys")
+	}
+}
+
+func TestCachedCount(t *testing.T) {
+	requireAetest(t)
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Counted", nil), datastore.NewIncompleteKey(c, "Counted", nil)}
+	key, err := PutMulti(c, key, []Struct{{1}, {2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := func(expected int) {
+		n, err := CachedCount(c, datastore.NewQuery("Counted"), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != expected {
+			t.Fatalf("expected=%#v actual=%#v", expected, n)
+		}
+	}
+	// miss
+	count(2)
+	// add an entity
+	extra, err := Put(c, datastore.NewIncompleteKey(c, "Counted", nil), &Struct{3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// hit
+	count(2)
+	// expired
+	time.Sleep(2 * time.Second)
+	count(3)
+	// DeleteMulti
+	err = DeleteMulti(c, append(key, extra))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,24 @@
+package cachestore
+
+import "context"
+
+type readOnlyKey struct{}
+
+// ReadOnly returns a copy of c for which Get, GetMulti and GetAll still read cached entities from memcache, but don't
+// cache the entities they read from datastore. Use it for scans that would otherwise evict frequently read entities
+// from memcache with ones that won't be read again.
+func ReadOnly(c context.Context) context.Context {
+	return context.WithValue(c, readOnlyKey{}, true)
+}
+
+// isReadOnly returns whether c was returned by ReadOnly.
+func isReadOnly(c context.Context) bool {
+	readOnly, _ := c.Value(readOnlyKey{}).(bool)
+	return readOnly
+}
+
+// readOnly returns whether reads with c mustn't cache what they read, because c was returned by ReadOnly or s is
+// MemcacheReadOnly.
+func (s *Cachestore) readOnly(c context.Context) bool {
+	return s.MemcacheReadOnly || isReadOnly(c)
+}
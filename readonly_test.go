@@ -0,0 +1,174 @@
+package cachestore
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+func TestReadOnly(t *testing.T) {
+	requireAetest(t)
+	src := &Struct{I: 5}
+	// Put
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get from datastore without caching
+	dst := &Struct{}
+	err = Get(ReadOnly(c), key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	items, _, err := defaultCachestore().getItems(c, []string{defaultCachestore().encodeKey(key)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, len(items))
+	}
+	// Get from memcache
+	err = Get(c, key, &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := Stats()
+	dst = &Struct{}
+	err = Get(ReadOnly(c), key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	if hits := Stats().Hits - before.Hits; hits != 1 {
+		t.Fatalf("expected=%#v actual=%#v", 1, hits)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeCountingMemcache is a MemcacheBackend that counts the calls writing to memcache.
+type writeCountingMemcache struct {
+	MemcacheBackend
+	writes *int32
+}
+
+func (m writeCountingMemcache) Add(c context.Context, item *memcache.Item) error {
+	atomic.AddInt32(m.writes, 1)
+	return m.MemcacheBackend.Add(c, item)
+}
+
+func (m writeCountingMemcache) AddMulti(c context.Context, item []*memcache.Item) error {
+	atomic.AddInt32(m.writes, 1)
+	return m.MemcacheBackend.AddMulti(c, item)
+}
+
+func (m writeCountingMemcache) SetMulti(c context.Context, item []*memcache.Item) error {
+	atomic.AddInt32(m.writes, 1)
+	return m.MemcacheBackend.SetMulti(c, item)
+}
+
+func (m writeCountingMemcache) CompareAndSwapMulti(c context.Context, item []*memcache.Item) error {
+	atomic.AddInt32(m.writes, 1)
+	return m.MemcacheBackend.CompareAndSwapMulti(c, item)
+}
+
+func (m writeCountingMemcache) DeleteMulti(c context.Context, key []string) error {
+	atomic.AddInt32(m.writes, 1)
+	return m.MemcacheBackend.DeleteMulti(c, key)
+}
+
+func TestMemcacheReadOnly(t *testing.T) {
+	requireAetest(t)
+	defer func() { MemcacheReadOnly, WriteThrough = false, false }()
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cache the first
+	err = Get(c, key[0], &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	MemcacheReadOnly = true
+	var writes int32
+	defer func(m MemcacheBackend) { memcacheBackend = m }(memcacheBackend)
+	memcacheBackend = writeCountingMemcache{memcacheBackend, &writes}
+	// GetMulti twice: the first from memcache, the second from datastore without caching it
+	for i := 0; i < 2; i++ {
+		before := Stats()
+		dst := make([]Struct, len(key))
+		err = GetMulti(c, key, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src, dst) {
+			t.Fatalf("expected=%#v actual=%#v", src, dst)
+		}
+		if reads := Stats().DatastoreReads - before.DatastoreReads; reads != 1 {
+			t.Fatalf("expected=%#v actual=%#v", 1, reads)
+		}
+	}
+	// PutMulti, with and without WriteThrough, leaves the first stale in memcache
+	for _, writeThrough := range []bool{false, true} {
+		WriteThrough = writeThrough
+		_, err = PutMulti(c, key, []Struct{{3}, {4}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst := &Struct{}
+		err = Get(c, key[0], dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(&src[0], dst) {
+			t.Fatalf("expected=%#v actual=%#v", &src[0], dst)
+		}
+	}
+	// GetOrLoad loads without caching, SetCacheOnly fails
+	for i := 0; i < 2; i++ {
+		loads := 0
+		err = GetOrLoad(c, "memcacheReadOnly", &Struct{}, 0, func() (interface{}, error) {
+			loads++
+			return &Struct{5}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loads != 1 {
+			t.Fatalf("expected=%#v actual=%#v", 1, loads)
+		}
+	}
+	err = SetCacheOnly(c, datastore.NewKey(c, "Struct", "memcacheReadOnly", 0, nil), &Struct{6}, 0)
+	if err != errNotAdded {
+		t.Fatalf("expected=%#v actual=%#v", errNotAdded, err)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writes != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, writes)
+	}
+	// remove the stale entity
+	MemcacheReadOnly = false
+	err = defaultCachestore().uncache(key, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,72 @@
+package cachestore
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// Refresh reloads the entity for key from datastore into dst and memcache using the default Cachestore. See
+// Cachestore.Refresh.
+func Refresh(c context.Context, key *datastore.Key, dst interface{}) error {
+	return defaultCachestore().Refresh(c, key, dst)
+}
+
+// RefreshMulti is a batch version of Refresh. See Cachestore.RefreshMulti.
+func RefreshMulti(c context.Context, key []*datastore.Key, dst interface{}) error {
+	return defaultCachestore().RefreshMulti(c, key, dst)
+}
+
+// Refresh loads the entity for key from datastore into dst like Get, without reading memcache, and caches it,
+// replacing any stale cached entity. If there's no such entity, or it can't be loaded, it's removed from memcache. Use
+// it to repopulate memcache after datastore was changed without cachestore.
+func (s *Cachestore) Refresh(c context.Context, key *datastore.Key, dst interface{}) error {
+	if !isEntity(reflect.ValueOf(dst)) {
+		return datastore.ErrInvalidEntityType
+	}
+	err := s.RefreshMulti(c, []*datastore.Key{key}, []interface{}{dst})
+	if me, ok := err.(appengine.MultiError); ok {
+		return me[0]
+	}
+	return err
+}
+
+// RefreshMulti is a batch version of Refresh. If reading datastore succeeds but writing memcache fails, the memcache
+// error is returned.
+func (s *Cachestore) RefreshMulti(c context.Context, key []*datastore.Key, dst interface{}) error {
+	if err := checkMultiLen(key, dst); err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return nil
+	}
+	s.debugf(c, "reading from datastore: %d keys", len(key))
+	start := time.Now()
+	errd := datastoreBackend.GetMulti(c, key, dst)
+	s.logSlowOp(c, "get", "datastore", len(key), start, errd)
+	me, ok := errd.(appengine.MultiError)
+	if errd != nil && !ok {
+		return errd
+	}
+	loaded, failed := *new([]int), *new([]*datastore.Key)
+	for i, k := range key {
+		if ok && me[i] != nil {
+			failed = append(failed, k)
+		} else {
+			loaded = append(loaded, i)
+		}
+	}
+	s.removeLocal(c, s.encodeKeys(key))
+	loadedKey, loadedDst := subset(key, reflect.ValueOf(dst), loaded)
+	errm := s.cache(loadedKey, loadedDst.Interface(), nil, c)
+	if err := s.evict(c, failed); err != nil && errm == nil {
+		errm = err
+	}
+	if errd != nil {
+		return errd
+	}
+	return errm
+}
@@ -0,0 +1,58 @@
+package cachestore
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestRefresh(t *testing.T) {
+	requireAetest(t)
+	src := &Struct{I: 1}
+	// Put and Get to cache
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Get(c, key, &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Put to datastore only, so the cached entity is stale
+	src = &Struct{I: 2}
+	_, err = datastore.Put(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Refresh
+	dst := &Struct{}
+	err = Refresh(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	dst = &Struct{}
+	err = GetMemcacheOnly(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// Delete from datastore only, so the cached entity is stale
+	err = datastore.Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Refresh(c, key, &Struct{})
+	if err != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+	err = Get(c, key, &Struct{})
+	if err != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+}
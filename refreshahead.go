@@ -0,0 +1,117 @@
+package cachestore
+
+import (
+	"context"
+	"encoding/binary"
+	"reflect"
+	"sync"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+// now returns the current time, tests replace it to age cached items.
+var now = time.Now
+
+// refreshing holds the encoded keys being refreshed ahead in this instance, so that concurrent reads of a stale entity
+// only reload it once.
+var refreshing = &refreshGroup{keys: map[string]bool{}}
+
+// refreshes counts the refreshes running in the background, so tests can wait for them.
+var refreshes sync.WaitGroup
+
+type refreshGroup struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+// start returns the indexes of encodedKeys that aren't already being refreshed, and marks them as being refreshed.
+func (g *refreshGroup) start(encodedKeys []string, index []int) []int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	started := *new([]int)
+	for _, j := range index {
+		if !g.keys[encodedKeys[j]] {
+			g.keys[encodedKeys[j]] = true
+			started = append(started, j)
+		}
+	}
+	return started
+}
+
+// finish marks the encoded keys at index as no longer being refreshed.
+func (g *refreshGroup) finish(encodedKeys []string, index []int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, j := range index {
+		delete(g.keys, encodedKeys[j])
+	}
+}
+
+// refreshAhead reloads the entities GetMulti decoded into dst from stale items in the background, with a Strong
+// context so they're read from datastore and cached like a GetMulti would cache them. The reloaded entities are
+// loaded into new values of the types of dst's elements, leaving dst unchanged.
+//
+// Entities read from the local caches are never stale, since the local caches don't keep when entities were cached.
+// The refreshes use c, so they may be cut short if c is canceled, for example when its request ends, and are then
+// retried by a later read.
+func (s *Cachestore) refreshAhead(c context.Context, key []*datastore.Key, encodedKeys []string, items map[string]*memcache.Item, dst interface{}, errs appengine.MultiError) {
+	stale := *new([]int)
+	for i, k := range encodedKeys {
+		if item := items[k]; item != nil && item.Flags&flagStale != 0 && errs[i] == nil {
+			stale = append(stale, i)
+		}
+	}
+	stale = refreshing.start(encodedKeys, stale)
+	if len(stale) == 0 {
+		return
+	}
+	v := reflect.ValueOf(dst)
+	multiArgType, _ := checkMultiArg(v)
+	staleKey, fresh := make([]*datastore.Key, len(stale)), make([]interface{}, len(stale))
+	for i, j := range stale {
+		e := elem(v, j, multiArgType)
+		if multiArgType == multiArgTypeInterface {
+			e = e.Elem()
+		}
+		staleKey[i], fresh[i] = key[j], newLike(e).Interface()
+	}
+	refreshes.Add(1)
+	go func() {
+		defer refreshes.Done()
+		defer refreshing.finish(encodedKeys, stale)
+		s.debugf(c, "refreshing from datastore: %d keys", len(staleKey))
+		if err := s.getEntities(Strong(c), staleKey, fresh, nil); err != nil {
+			s.debugf(c, "refreshing from datastore: %v", err)
+		}
+	}()
+}
+
+// newLike returns a new value to load the entity loaded into e into: a pointer to a new value if e is a pointer, or
+// else a new value of e's type, an empty map if it's a map, for PropertyLoadSavers that aren't pointers.
+func newLike(e reflect.Value) reflect.Value {
+	switch e.Kind() {
+	case reflect.Ptr:
+		return reflect.New(e.Type().Elem())
+	case reflect.Map:
+		return reflect.MakeMap(e.Type())
+	}
+	return reflect.New(e.Type()).Elem()
+}
+
+// stampWritten returns value prefixed by when it was written.
+func stampWritten(value []byte, written time.Time) []byte {
+	stamped := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(stamped, uint64(written.UnixNano()))
+	return append(stamped, value...)
+}
+
+// unstampWritten returns when value was written and value without its prefix, and whether it was prefixed.
+func unstampWritten(value []byte) (time.Time, []byte, bool) {
+	if len(value) < 8 {
+		return time.Time{}, nil, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(value))), value[8:], true
+}
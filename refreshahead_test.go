@@ -0,0 +1,175 @@
+package cachestore
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestRefreshAhead(t *testing.T) {
+	requireAetest(t)
+	RefreshAfter = time.Hour
+	defer func() { RefreshAfter, now = 0, time.Now }()
+	src := &Struct{I: 3}
+	// Put
+	key, err := Put(c, datastore.NewKey(c, "Struct", "refreshAhead", 0, nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get from datastore two hours ago
+	now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+	err = Get(c, key, &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = time.Now
+	// update datastore without cachestore
+	updated := &Struct{I: 4}
+	_, err = datastore.Put(c, key, updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get the aged entity from memcache, refreshing it in the background
+	before := Stats()
+	dst := []interface{}{&Struct{}}
+	err = GetMulti(c, []*datastore.Key{key}, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst[0]) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst[0])
+	}
+	refreshes.Wait()
+	if reads := Stats().DatastoreReads - before.DatastoreReads; reads != 1 {
+		t.Fatalf("expected=%#v actual=%#v", 1, reads)
+	}
+	// Get the refreshed entity from memcache
+	before = Stats()
+	dst[0] = &Struct{}
+	err = GetMulti(c, []*datastore.Key{key}, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(updated, dst[0]) {
+		t.Fatalf("expected=%#v actual=%#v", updated, dst[0])
+	}
+	refreshes.Wait()
+	if reads := Stats().DatastoreReads - before.DatastoreReads; reads != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, reads)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshAheadSkipsFreshItems(t *testing.T) {
+	requireAetest(t)
+	RefreshAfter = time.Hour
+	defer func() { RefreshAfter = 0 }()
+	src := &Struct{I: 3}
+	// Put
+	key, err := Put(c, datastore.NewKey(c, "Struct", "refreshAheadFresh", 0, nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get from datastore
+	err = Get(c, key, &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// update datastore without cachestore
+	_, err = datastore.Put(c, key, &Struct{I: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get from memcache twice
+	for i := 0; i < 2; i++ {
+		dst := &Struct{}
+		err = Get(c, key, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src, dst) {
+			t.Fatalf("expected=%#v actual=%#v", src, dst)
+		}
+		refreshes.Wait()
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// PropertyMap is a PropertyLoadSaver that isn't a pointer
+type PropertyMap map[string]interface{}
+
+func (m PropertyMap) Load(ps []datastore.Property) error {
+	for _, p := range ps {
+		m[p.Name] = p.Value
+	}
+	return nil
+}
+
+func (m PropertyMap) Save() ([]datastore.Property, error) {
+	ps := *new([]datastore.Property)
+	for name, value := range m {
+		ps = append(ps, datastore.Property{Name: name, Value: value})
+	}
+	return ps, nil
+}
+
+func TestRefreshAheadIntoInterfaces(t *testing.T) {
+	requireAetest(t)
+	RefreshAfter = time.Hour
+	defer func() { RefreshAfter, now = 0, time.Now }()
+	key := []*datastore.Key{datastore.NewKey(c, "Struct", "refreshAheadStruct", 0, nil), datastore.NewKey(c, "Struct", "refreshAheadMap", 0, nil)}
+	// PutMulti
+	_, err := PutMulti(c, key, []Struct{{1}, {2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti from datastore two hours ago
+	now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+	err = GetMulti(c, key, []interface{}{&Struct{}, PropertyMap{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = time.Now
+	// update datastore without cachestore
+	updated := []Struct{{3}, {4}}
+	_, err = datastore.PutMulti(c, key, updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti the aged entities from memcache, refreshing them in the background
+	err = GetMulti(c, key, []interface{}{&Struct{}, PropertyMap{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshes.Wait()
+	// GetMulti the refreshed entities from memcache
+	before := Stats()
+	m := PropertyMap{}
+	dst := []interface{}{&Struct{}, m}
+	err = GetMulti(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&updated[0], dst[0]) || !reflect.DeepEqual(PropertyMap{"I": int64(4)}, m) {
+		t.Fatalf("expected=%#v actual=%#v", updated, dst)
+	}
+	refreshes.Wait()
+	if reads := Stats().DatastoreReads - before.DatastoreReads; reads != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, reads)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,22 @@
+package cachestore
+
+import "context"
+
+type requestCacheKey struct{}
+
+// maxRequestCacheSize is the maximum number of entities a request cache holds.
+const maxRequestCacheSize = 1000
+
+// WithRequestCache returns a copy of c that caches the entities read with it in-process, so that reading them again
+// with a context derived from it returns them without reading memcache. Put, PutMulti, Delete and DeleteMulti remove
+// their entities from the request cache. Use it for the context of a single request: unlike LocalCache, writes made
+// by other requests aren't seen until the request ends.
+func WithRequestCache(c context.Context) context.Context {
+	return context.WithValue(c, requestCacheKey{}, NewLRU(maxRequestCacheSize))
+}
+
+// requestCacheFromContext returns the request cache of c, or nil if c wasn't returned by WithRequestCache.
+func requestCacheFromContext(c context.Context) *LRU {
+	r, _ := c.Value(requestCacheKey{}).(*LRU)
+	return r
+}
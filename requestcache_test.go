@@ -0,0 +1,70 @@
+package cachestore
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestWithRequestCache(t *testing.T) {
+	requireAetest(t)
+	src := &Struct{I: 4}
+	// Put and Get to cache
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Get(c, key, &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get twice in a request
+	var gets int64
+	ctx := WithRequestCache(appengine.WithAPICallFunc(c, func(ctx context.Context, service, method string, in, out proto.Message) error {
+		if service == "memcache" && method == "Get" {
+			atomic.AddInt64(&gets, 1)
+		}
+		return appengine.APICall(ctx, service, method, in, out)
+	}))
+	for i := 0; i < 2; i++ {
+		dst := &Struct{}
+		err = Get(ctx, key, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src, dst) {
+			t.Fatalf("expected=%#v actual=%#v", src, dst)
+		}
+	}
+	if gets != 1 {
+		t.Fatalf("expected=%#v actual=%#v", 1, gets)
+	}
+	// Put in the request
+	src = &Struct{I: 5}
+	_, err = Put(ctx, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := &Struct{}
+	err = Get(ctx, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// Delete in the request
+	err = Delete(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Get(ctx, key, &Struct{})
+	if err != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+}
@@ -0,0 +1,27 @@
+package cachestore
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/appengine/memcache"
+)
+
+// retry calls f, a memcache call, and calls it again up to MemcacheRetries times while it fails with
+// memcache.ErrServerError, waiting MemcacheRetryBackoff before the first retry and twice as long before each of the
+// next. Other errors, like ErrCacheMiss, ErrNotStored or a MultiError of the items that failed, are returned
+// immediately, since calling again wouldn't change them. It stops waiting if c is done, returning f's last error.
+func (s *Cachestore) retry(c context.Context, f func() error) error {
+	err := f()
+	backoff := s.MemcacheRetryBackoff
+	for attempt := 0; attempt < s.MemcacheRetries && err == memcache.ErrServerError; attempt++ {
+		select {
+		case <-time.After(backoff):
+		case <-c.Done():
+			return err
+		}
+		backoff *= 2
+		err = f()
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+package cachestore
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+// flakyMemcache is a MemcacheBackend whose GetMulti, SetMulti and DeleteMulti fail with ErrServerError the first time each
+// is called.
+type flakyMemcache struct {
+	MemcacheBackend
+	mu     sync.Mutex
+	failed map[string]bool
+}
+
+func (m *flakyMemcache) fail(method string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failed[method] {
+		return false
+	}
+	m.failed[method] = true
+	return true
+}
+
+func (m *flakyMemcache) GetMulti(c context.Context, key []string) (map[string]*memcache.Item, error) {
+	if m.fail("GetMulti") {
+		return nil, memcache.ErrServerError
+	}
+	return m.MemcacheBackend.GetMulti(c, key)
+}
+
+func (m *flakyMemcache) SetMulti(c context.Context, item []*memcache.Item) error {
+	if m.fail("SetMulti") {
+		return memcache.ErrServerError
+	}
+	return m.MemcacheBackend.SetMulti(c, item)
+}
+
+func (m *flakyMemcache) DeleteMulti(c context.Context, key []string) error {
+	if m.fail("DeleteMulti") {
+		return memcache.ErrServerError
+	}
+	return m.MemcacheBackend.DeleteMulti(c, key)
+}
+
+func TestMemcacheRetries(t *testing.T) {
+	requireAetest(t)
+	WriteThrough, MemcacheRetryBackoff = true, time.Millisecond
+	defer func() { WriteThrough, MemcacheRetries, MemcacheRetryBackoff = false, 0, 10*time.Millisecond }()
+	defer func(m MemcacheBackend) { memcacheBackend = m }(memcacheBackend)
+	key := datastore.NewKey(c, "Struct", "memcacheRetries", 0, nil)
+	// without retries, Put fails to cache and then to evict
+	memcacheBackend = &flakyMemcache{MemcacheBackend: appengineMemcache{}, failed: map[string]bool{}}
+	_, err := Put(c, key, &Struct{I: 1})
+	if err != memcache.ErrServerError {
+		t.Fatalf("expected=%#v actual=%#v", memcache.ErrServerError, err)
+	}
+	// with retries, Put caches, and Get reads memcache
+	MemcacheRetries = 1
+	memcacheBackend = &flakyMemcache{MemcacheBackend: appengineMemcache{}, failed: map[string]bool{}}
+	src := &Struct{I: 2}
+	_, err = Put(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := Stats()
+	dst := &Struct{}
+	err = Get(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *src != *dst {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	if reads := Stats().DatastoreReads - before.DatastoreReads; reads != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, reads)
+	}
+	// Delete retries the failing DeleteMulti
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = GetMemcacheOnly(c, key, &Struct{})
+	if err != memcache.ErrCacheMiss {
+		t.Fatalf("expected=%#v actual=%#v", memcache.ErrCacheMiss, err)
+	}
+}
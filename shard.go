@@ -0,0 +1,144 @@
+package cachestore
+
+import (
+	"context"
+	"hash/fnv"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/memcache"
+)
+
+// ShardedMemcache returns a MemcacheBackend that spreads cached entities across shards, for example the nodes of a
+// self-managed memcache cluster, by their memcache keys: KeyPrefix followed by the encoded datastore key. shard returns
+// the index in [0, n) of the shard of key, nil means jump consistent hashing, which only moves about 1/n of the keys
+// when an nth shard is added. Batch calls are split into one call per shard and their results merged. Set it as
+// Memcache:
+//
+//	cachestore.Memcache = cachestore.ShardedMemcache(nil, node0, node1, node2)
+func ShardedMemcache(shard func(key string, n int) int, shards ...MemcacheBackend) MemcacheBackend {
+	if shard == nil {
+		shard = jumpHash
+	}
+	return &shardedMemcache{shards: shards, shard: shard}
+}
+
+// shardedMemcache is the MemcacheBackend returned by ShardedMemcache. Each key is read and written on the shard the
+// shard func picks for it, so that compare-and-swaps go back to the backend the items were read from.
+type shardedMemcache struct {
+	shards []MemcacheBackend
+	shard  func(key string, n int) int
+}
+
+// jumpHash returns the shard in [0, n) of key by jump consistent hashing. See https://arxiv.org/abs/1406.2294.
+func jumpHash(key string, n int) int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	k := h.Sum64()
+	b, j := int64(-1), int64(0)
+	for j < int64(n) {
+		b = j
+		k = k*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((k>>33)+1)))
+	}
+	return int(b)
+}
+
+// split returns the indexes of the n keys key returns for each shard.
+func (m *shardedMemcache) split(n int, key func(i int) string) [][]int {
+	indexes := make([][]int, len(m.shards))
+	for i := 0; i < n; i++ {
+		j := m.shard(key(i), len(m.shards))
+		indexes[j] = append(indexes[j], i)
+	}
+	return indexes
+}
+
+// each calls f for each shard with the indexes of the n keys key returns that it holds, skipping shards without any.
+// Like batch, it merges the appengine.MultiErrors f returns into one for all n items, and stops at the first other
+// error.
+func (m *shardedMemcache) each(n int, key func(i int) string, f func(shard MemcacheBackend, index []int) error) error {
+	var multiErr appengine.MultiError
+	for j, index := range m.split(n, key) {
+		if len(index) == 0 {
+			continue
+		}
+		err := f(m.shards[j], index)
+		if me, ok := err.(appengine.MultiError); ok {
+			if multiErr == nil {
+				multiErr = make(appengine.MultiError, n)
+			}
+			for i, k := range index {
+				multiErr[k] = me[i]
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+	if multiErr != nil {
+		return multiErr
+	}
+	return nil
+}
+
+func (m *shardedMemcache) Get(c context.Context, key string) (*memcache.Item, error) {
+	return m.shards[m.shard(key, len(m.shards))].Get(c, key)
+}
+
+// GetMulti merges the items found on every shard. If a shard fails, the items of the shards before it are returned
+// with its error.
+func (m *shardedMemcache) GetMulti(c context.Context, key []string) (map[string]*memcache.Item, error) {
+	items := make(map[string]*memcache.Item, len(key))
+	err := m.each(len(key), func(i int) string { return key[i] }, func(shard MemcacheBackend, index []int) error {
+		shardKey := make([]string, len(index))
+		for i, k := range index {
+			shardKey[i] = key[k]
+		}
+		shardItems, err := shard.GetMulti(c, shardKey)
+		for k, item := range shardItems {
+			items[k] = item
+		}
+		return err
+	})
+	return items, err
+}
+
+func (m *shardedMemcache) Add(c context.Context, item *memcache.Item) error {
+	return m.shards[m.shard(item.Key, len(m.shards))].Add(c, item)
+}
+
+func (m *shardedMemcache) AddMulti(c context.Context, item []*memcache.Item) error {
+	return m.eachItems(c, item, MemcacheBackend.AddMulti)
+}
+
+func (m *shardedMemcache) SetMulti(c context.Context, item []*memcache.Item) error {
+	return m.eachItems(c, item, MemcacheBackend.SetMulti)
+}
+
+func (m *shardedMemcache) CompareAndSwapMulti(c context.Context, item []*memcache.Item) error {
+	return m.eachItems(c, item, MemcacheBackend.CompareAndSwapMulti)
+}
+
+// eachItems calls f on each shard with the items whose keys it holds.
+func (m *shardedMemcache) eachItems(c context.Context, item []*memcache.Item, f func(MemcacheBackend, context.Context, []*memcache.Item) error) error {
+	return m.each(len(item), func(i int) string { return item[i].Key }, func(shard MemcacheBackend, index []int) error {
+		shardItem := make([]*memcache.Item, len(index))
+		for i, k := range index {
+			shardItem[i] = item[k]
+		}
+		return f(shard, c, shardItem)
+	})
+}
+
+func (m *shardedMemcache) DeleteMulti(c context.Context, key []string) error {
+	return m.each(len(key), func(i int) string { return key[i] }, func(shard MemcacheBackend, index []int) error {
+		shardKey := make([]string, len(index))
+		for i, k := range index {
+			shardKey[i] = key[k]
+		}
+		return shard.DeleteMulti(c, shardKey)
+	})
+}
+
+func (m *shardedMemcache) Increment(c context.Context, key string, delta int64, initialValue uint64) (uint64, error) {
+	return m.shards[m.shard(key, len(m.shards))].Increment(c, key, delta, initialValue)
+}
@@ -0,0 +1,131 @@
+package cachestore
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+func TestShardedMemcache(t *testing.T) {
+	withFakeBackends(func(m *fakeMemcache, d *fakeDatastore) {
+		c := context.Background()
+		shards := []*fakeMemcache{newFakeMemcache(), newFakeMemcache()}
+		s := New()
+		s.Memcache = ShardedMemcache(nil, shards[0], shards[1])
+		src := make([]Struct, 20)
+		key := make([]*datastore.Key, len(src))
+		for i := range src {
+			src[i], key[i] = Struct{I: i}, datastore.NewIncompleteKey(c, "Struct", nil)
+		}
+		// PutMulti, then GetMulti from datastore and from memcache
+		key, err := s.PutMulti(c, key, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			dst := make([]Struct, len(key))
+			err = s.GetMulti(c, key, dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(src, dst) {
+				t.Fatalf("expected=%#v actual=%#v", src, dst)
+			}
+			if d.reads != len(key) {
+				t.Fatalf("expected=%#v actual=%#v", len(key), d.reads)
+			}
+		}
+		// each key is cached on exactly its shard, and both shards are used
+		used := make([]int, len(shards))
+		for _, k := range s.encodeKeys(key) {
+			j := jumpHash(k, len(shards))
+			used[j]++
+			for i, shard := range shards {
+				if _, ok := shard.items[k]; ok != (i == j) {
+					t.Fatalf("expected=%#v actual=%#v", i == j, ok)
+				}
+			}
+		}
+		if used[0] == 0 || used[1] == 0 {
+			t.Fatalf("expected keys on both shards, actual=%#v", used)
+		}
+		// nothing is cached in the default backend
+		if len(m.items) != 0 {
+			t.Fatalf("expected=%#v actual=%#v", 0, len(m.items))
+		}
+		// AddMulti merges the errors of both shards in order
+		item := make([]*memcache.Item, 0, len(key)+1)
+		expected := make(appengine.MultiError, 0, len(key)+1)
+		for _, k := range s.encodeKeys(key) {
+			item = append(item, &memcache.Item{Key: k})
+			expected = append(expected, memcache.ErrNotStored)
+		}
+		item = append(item, &memcache.Item{Key: "shardAbsent"})
+		expected = append(expected, nil)
+		err = s.Memcache.AddMulti(c, item)
+		if !reflect.DeepEqual(expected, err) {
+			t.Fatalf("expected=%#v actual=%#v", expected, err)
+		}
+		// DeleteMulti removes from both shards
+		err = s.DeleteMulti(c, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, shard := range shards {
+			for _, k := range s.encodeKeys(key) {
+				if _, ok := shard.items[k]; ok {
+					t.Fatalf("expected=%#v actual=%#v", false, ok)
+				}
+			}
+		}
+	})
+}
+
+func TestShardedMemcacheShardFunc(t *testing.T) {
+	withFakeBackends(func(m *fakeMemcache, d *fakeDatastore) {
+		c := context.Background()
+		shards := []*fakeMemcache{newFakeMemcache(), newFakeMemcache()}
+		key := []*datastore.Key{datastore.NewKey(c, "Struct", "shard0", 0, nil), datastore.NewKey(c, "Struct", "shard1", 0, nil)}
+		// the package's functions use the Memcache variable, here with a shard func sending key[1] to the second shard
+		second := defaultCachestore().encodeKey(key[1])
+		Memcache = ShardedMemcache(func(key string, n int) int {
+			if key == second {
+				return 1
+			}
+			return 0
+		}, shards[0], shards[1])
+		defer func() { Memcache = nil }()
+		key, err := PutMulti(c, key, []Struct{{0}, {1}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = GetMulti(c, key, make([]Struct, len(key)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, k := range defaultCachestore().encodeKeys(key) {
+			if _, ok := shards[i].items[k]; !ok {
+				t.Fatalf("expected=%#v actual=%#v", true, ok)
+			}
+			if _, ok := shards[1-i].items[k]; ok {
+				t.Fatalf("expected=%#v actual=%#v", false, ok)
+			}
+		}
+	})
+}
+
+func TestJumpHash(t *testing.T) {
+	// adding a shard only moves keys to the new shard
+	for i := 0; i < 1000; i++ {
+		k := strconv.Itoa(i)
+		before, after := jumpHash(k, 3), jumpHash(k, 4)
+		if before < 0 || before >= 3 || (after != before && after != 3) {
+			t.Fatalf("key %q moved from shard %d to %d", k, before, after)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package cachestore
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+// inflight coalesces concurrent datastore loads of the same keys by GetMulti, so that when a popular key misses
+// memcache only one call reads it from datastore and the others wait for its result.
+var inflight = &loadGroup{loads: map[string]*load{}}
+
+var errLoadAbandoned = errors.New("cachestore: concurrent datastore load failed")
+
+// load is a datastore load of a key shared by concurrent calls. It holds the entity's properties rather than its
+// encoding or a loaded struct, so that each call loads them into its own dst, with its own Cachestore's configuration.
+type load struct {
+	done       chan struct{} // closed when properties and err are set
+	properties []datastore.Property
+	err        error
+}
+
+// loadGroup holds the in-flight loads by encoded key.
+type loadGroup struct {
+	mu    sync.Mutex
+	loads map[string]*load
+}
+
+// start returns the loads of the keys at the missing indexes, and the indexes whose loads the caller must perform
+// (lead) and those whose loads are already being performed by other calls, or by the caller for a duplicate key at
+// another index (follow).
+func (g *loadGroup) start(encodedKeys []string, missing []int) (map[int]*load, []int, []int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	loads, lead, follow := make(map[int]*load, len(missing)), *new([]int), *new([]int)
+	for _, j := range missing {
+		k := encodedKeys[j]
+		l, ok := g.loads[k]
+		if ok {
+			follow = append(follow, j)
+		} else {
+			l = &load{done: make(chan struct{}), err: errLoadAbandoned}
+			g.loads[k] = l
+			lead = append(lead, j)
+		}
+		loads[j] = l
+	}
+	return loads, lead, follow
+}
+
+// startAlone is start for a call that mustn't share loads with other calls: the caller performs the loads of the
+// missing indexes, without adding them to the group.
+func startAlone(encodedKeys []string, missing []int) (map[int]*load, []int, []int) {
+	loads, lead, follow := make(map[int]*load, len(missing)), *new([]int), *new([]int)
+	mine := make(map[string]*load)
+	for _, j := range missing {
+		k := encodedKeys[j]
+		l, ok := mine[k]
+		if ok {
+			follow = append(follow, j)
+		} else {
+			l = &load{done: make(chan struct{}), err: errLoadAbandoned}
+			mine[k] = l
+			lead = append(lead, j)
+		}
+		loads[j] = l
+	}
+	return loads, lead, follow
+}
+
+// finish removes l from the group and wakes the calls waiting for it. Finishing a load more than once is a no-op.
+func (g *loadGroup) finish(encodedKey string, l *load) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.loads[encodedKey] == l {
+		delete(g.loads, encodedKey)
+	}
+	select {
+	case <-l.done:
+	default:
+		close(l.done)
+	}
+}
+
+// finishAll finishes the loads of the lead indexes, in case they weren't finished by shareLoads.
+func (g *loadGroup) finishAll(encodedKeys []string, lead []int, loads map[int]*load) {
+	for _, j := range lead {
+		g.finish(encodedKeys[j], loads[j])
+	}
+}
+
+// shareLoads finishes the loads of the lead indexes so waiting calls can load their properties, and encodes the
+// entities that were read. It returns the encoded entities as memcache items, and the first encoding error.
+func (s *Cachestore) shareLoads(key []*datastore.Key, encodedKeys []string, lead []int, loads map[int]*load) ([]*memcache.Item, error) {
+	items := *new([]*memcache.Item)
+	var err error
+	for _, j := range lead {
+		l := loads[j]
+		inflight.finish(encodedKeys[j], l)
+		if l.err != nil {
+			continue
+		}
+		value, erre := s.codec().Marshal(l.properties)
+		if erre != nil && err == nil {
+			err = erre
+		} else if erre == nil {
+			items = append(items, s.newEntityItem(key[j], encodedKeys[j], value))
+		}
+	}
+	return items, err
+}
+
+// waitLoads waits for the loads of the follow indexes by other calls and loads their results into dst.
+func (s *Cachestore) waitLoads(key []*datastore.Key, dst interface{}, follow []int, loads map[int]*load, errs appengine.MultiError) {
+	v := reflect.ValueOf(dst)
+	multiArgType, _ := checkMultiArg(v)
+	for _, j := range follow {
+		l := loads[j]
+		<-l.done
+		if l.err != nil {
+			errs[j] = l.err
+		} else {
+			// a copy, since the properties are shared with the other calls
+			errs[j] = s.loadElem(v, j, multiArgType, key[j], append([]datastore.Property(nil), l.properties...))
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package cachestore
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/appengine/log"
+)
+
+// logSlowOp logs op, a get, put or delete of n keys on backend, memcache or datastore, that started at start and
+// failed with err, to SlowOpLogger if it took at least SlowOpThreshold. It only logs the timing and outcome of the
+// operation, never the entities, and not to the debug Logger that does log them, so it's safe to enable in production.
+func (s *Cachestore) logSlowOp(c context.Context, op, backend string, n int, start time.Time, err error) {
+	if s.SlowOpThreshold <= 0 {
+		return
+	}
+	d := time.Since(start)
+	if d < s.SlowOpThreshold {
+		return
+	}
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	logf := log.Warningf
+	if s.SlowOpLogger != nil {
+		logf = s.SlowOpLogger.Debugf
+	}
+	logf(c, "slow operation: op=%s backend=%s keys=%d duration=%v outcome=%s", op, backend, n, d, outcome)
+}
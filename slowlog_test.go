@@ -0,0 +1,83 @@
+package cachestore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// recordingLogger records the lines it's given
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) Debugf(c context.Context, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+// slowGetDatastore is a datastorer whose GetMulti takes at least delay.
+type slowGetDatastore struct {
+	datastorer
+	delay time.Duration
+}
+
+func (d slowGetDatastore) GetMulti(c context.Context, key []*datastore.Key, dst interface{}) error {
+	time.Sleep(d.delay)
+	return d.datastorer.GetMulti(c, key, dst)
+}
+
+func TestSlowOpThreshold(t *testing.T) {
+	withFakeBackends(func(m *fakeMemcache, d *fakeDatastore) {
+		c := context.Background()
+		s := New()
+		s.SlowOpThreshold = 10 * time.Millisecond
+		// Put
+		key, err := s.Put(c, datastore.NewKey(c, "Struct", "slow", 0, nil), &Struct{I: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Get from a slow datastore
+		l, debug := &recordingLogger{}, &recordingLogger{}
+		s.SlowOpLogger, s.Logger = l, debug
+		datastoreBackend = slowGetDatastore{d, 20 * time.Millisecond}
+		err = s.Get(c, key, &Struct{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		slow := *new([]string)
+		for _, line := range l.lines {
+			if strings.HasPrefix(line, "slow operation: ") {
+				slow = append(slow, line)
+			}
+		}
+		prefix := "slow operation: op=get backend=datastore keys=1 duration="
+		if len(slow) != 1 || !strings.HasPrefix(slow[0], prefix) || !strings.HasSuffix(slow[0], " outcome=ok") {
+			t.Fatalf("expected=%#v actual=%#v", prefix+"... outcome=ok", slow)
+		}
+		// nothing slow in the debug info
+		for _, line := range debug.lines {
+			if strings.HasPrefix(line, "slow operation: ") {
+				t.Fatalf("expected no slow operation, actual=%#v", line)
+			}
+		}
+		// Get from memcache
+		l.lines = nil
+		err = s.Get(c, key, &Struct{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range l.lines {
+			if strings.HasPrefix(line, "slow operation: ") {
+				t.Fatalf("expected no slow operation, actual=%#v", line)
+			}
+		}
+	})
+}
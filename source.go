@@ -0,0 +1,57 @@
+package cachestore
+
+import (
+	"context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// Source is where GetMultiWithSource loaded an entity from.
+type Source int
+
+const (
+	Missing       Source = iota // not loaded: there's no such entity, or reading it failed
+	FromMemcache                // loaded from memcache, or from LocalCache or a request cache
+	FromDatastore               // loaded from datastore
+)
+
+func (s Source) String() string {
+	switch s {
+	case FromMemcache:
+		return "FromMemcache"
+	case FromDatastore:
+		return "FromDatastore"
+	}
+	return "Missing"
+}
+
+// GetMultiWithSource is GetMulti using the default Cachestore, also returning where each entity was loaded from.
+// See Cachestore.GetMultiWithSource.
+func GetMultiWithSource(c context.Context, key []*datastore.Key, dst interface{}) ([]Source, error) {
+	return defaultCachestore().GetMultiWithSource(c, key, dst)
+}
+
+// GetMultiWithSource is GetMulti, also returning where each entity was loaded from, for debugging and cost analysis.
+// It caches exactly like GetMulti. The sources are only valid if the error is nil or an appengine.MultiError.
+func (s *Cachestore) GetMultiWithSource(c context.Context, key []*datastore.Key, dst interface{}) ([]Source, error) {
+	sources := make([]Source, len(key))
+	err := s.getEntities(c, key, dst, sources)
+	return sources, err
+}
+
+// setSources sets the sources of entities whose keys are at the missing indexes from datastore, and the others from
+// memcache, unless they weren't loaded according to errs.
+func setSources(sources []Source, missing []int, errs appengine.MultiError) {
+	for j := range sources {
+		sources[j] = FromMemcache
+	}
+	for _, j := range missing {
+		sources[j] = FromDatastore
+	}
+	for j, err := range errs {
+		if _, ok := err.(*datastore.ErrFieldMismatch); err != nil && !ok {
+			sources[j] = Missing
+		}
+	}
+}
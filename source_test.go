@@ -0,0 +1,54 @@
+package cachestore
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+func TestGetMultiWithSource(t *testing.T) {
+	requireAetest(t)
+	src := []Struct{{1}, {2}, {3}}
+	key := []*datastore.Key{
+		datastore.NewIncompleteKey(c, "Struct", nil),
+		datastore.NewIncompleteKey(c, "Struct", nil),
+		datastore.NewIncompleteKey(c, "Struct", nil),
+	}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti to cache
+	err = GetMulti(c, key, make([]Struct, len(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// partial hit, with a missing entity and an incomplete key
+	err = memcache.Delete(c, defaultCachestore().encodeKey(key[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key = append(key, datastore.NewKey(c, "Struct", "missing", 0, nil), datastore.NewIncompleteKey(c, "Struct", nil))
+	dst := make([]Struct, len(key))
+	sources, err := GetMultiWithSource(c, key, dst)
+	me, ok := err.(appengine.MultiError)
+	if !ok || me[3] != datastore.ErrNoSuchEntity || me[4] != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+	if !reflect.DeepEqual(src, dst[:len(src)]) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst[:len(src)])
+	}
+	expected := []Source{FromMemcache, FromDatastore, FromMemcache, Missing, Missing}
+	if !reflect.DeepEqual(expected, sources) {
+		t.Fatalf("expected=%v actual=%v", expected, sources)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key[:len(src)])
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,56 @@
+package cachestore
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Counters count the keys read by GetMulti (and Get) since the instance started.
+type Counters struct {
+	Calls          uint64 // GetMulti calls
+	Keys           uint64 // keys requested
+	Hits           uint64 // keys found in memcache
+	Misses         uint64 // keys not found in memcache
+	DatastoreReads uint64 // keys read from datastore, fewer than Misses if concurrent calls shared loads
+	CacheErrors    uint64 // keys read from datastore that couldn't be cached
+}
+
+var counters Counters
+
+// Stats returns a snapshot of the counters.
+func Stats() Counters {
+	return Counters{
+		Calls:          atomic.LoadUint64(&counters.Calls),
+		Keys:           atomic.LoadUint64(&counters.Keys),
+		Hits:           atomic.LoadUint64(&counters.Hits),
+		Misses:         atomic.LoadUint64(&counters.Misses),
+		DatastoreReads: atomic.LoadUint64(&counters.DatastoreReads),
+		CacheErrors:    atomic.LoadUint64(&counters.CacheErrors),
+	}
+}
+
+type callCountedKey struct{}
+
+// callCounted returns a copy of c for which count adds keys but no call, for the phases of a call already counted.
+func callCounted(c context.Context) context.Context {
+	return context.WithValue(c, callCountedKey{}, true)
+}
+
+// count adds a GetMulti call for keys keys, of which misses weren't found in memcache and reads were read from
+// datastore. The call isn't added if c was returned by callCounted.
+func count(c context.Context, keys, misses, reads int) {
+	if counted, _ := c.Value(callCountedKey{}).(bool); !counted {
+		atomic.AddUint64(&counters.Calls, 1)
+	}
+	atomic.AddUint64(&counters.Keys, uint64(keys))
+	atomic.AddUint64(&counters.Hits, uint64(keys-misses))
+	atomic.AddUint64(&counters.Misses, uint64(misses))
+	atomic.AddUint64(&counters.DatastoreReads, uint64(reads))
+}
+
+// countCacheErrors adds n keys that GetMulti read from datastore but couldn't cache.
+func countCacheErrors(n int) {
+	if n > 0 {
+		atomic.AddUint64(&counters.CacheErrors, uint64(n))
+	}
+}
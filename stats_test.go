@@ -0,0 +1,60 @@
+package cachestore
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+func TestStats(t *testing.T) {
+	requireAetest(t)
+	src := make([]Struct, 4)
+	key := make([]*datastore.Key, len(src))
+	for i := range src {
+		src[i] = Struct{I: i}
+		key[i] = datastore.NewIncompleteKey(c, "Struct", nil)
+	}
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := Stats()
+	// all miss
+	dst := make([]Struct, len(src))
+	err = GetMulti(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// all hit
+	err = GetMulti(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// partial hit
+	err = memcache.Delete(c, defaultCachestore().encodeKey(key[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = GetMulti(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := Stats()
+	expected := Counters{Calls: 3, Keys: 12, Hits: 7, Misses: 5, DatastoreReads: 5}
+	actual := Counters{
+		Calls:          after.Calls - before.Calls,
+		Keys:           after.Keys - before.Keys,
+		Hits:           after.Hits - before.Hits,
+		Misses:         after.Misses - before.Misses,
+		DatastoreReads: after.DatastoreReads - before.DatastoreReads,
+	}
+	if expected != actual {
+		t.Fatalf("expected=%#v actual=%#v", expected, actual)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,108 @@
+package cachestore
+
+import (
+	"context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+// Result is an entity sent by GetStream.
+type Result struct {
+	Key    *datastore.Key
+	Entity interface{} // the value returned by proto the entity was loaded into
+	Source Source
+	Err    error // the error GetMulti would return for Key
+}
+
+// GetStream loads the entities for key into new values returned by proto using the default Cachestore, and sends
+// them as they're loaded. See Cachestore.GetStream.
+func GetStream(c context.Context, key []*datastore.Key, proto func() interface{}) (<-chan Result, error) {
+	return defaultCachestore().GetStream(c, key, proto)
+}
+
+// GetStream loads the entities for key like GetMulti, into values returned by proto which must be valid dsts for Get,
+// but sends each entity on the returned channel as soon as it's loaded instead of returning once they all are: first
+// the ones read from memcache, then the ones read from datastore, which are read and cached like GetMulti. Every key
+// gets exactly one Result, with the error GetMulti would return for it, and the channel is closed after the last one.
+// The channel is buffered, so consumers may stop reading it early without blocking GetStream.
+//
+// Keys are read from memcache and datastore in the background, so GetStream only returns an error if key or the
+// values returned by proto are invalid. Strong, WithBypass and RunInTransaction contexts skip memcache, so with them
+// every entity is sent once they're all read from datastore.
+func (s *Cachestore) GetStream(c context.Context, key []*datastore.Key, proto func() interface{}) (<-chan Result, error) {
+	dst := make([]interface{}, len(key))
+	for i := range dst {
+		dst[i] = proto()
+	}
+	if err := checkMultiLen(key, dst); err != nil {
+		return nil, err
+	}
+	results := make(chan Result, len(key))
+	// count the call once here, the memcache pass and the datastore fallback only add their keys
+	count(c, 0, 0, 0)
+	c = callCounted(c)
+	go func() {
+		defer close(results)
+		missing := make([]int, 0, len(key))
+		if isStrong(c) || isBypass(c) || transactionFromContext(c) != nil {
+			for i := range key {
+				missing = append(missing, i)
+			}
+		} else {
+			missing = s.streamCached(c, key, dst, results, missing)
+		}
+		if len(missing) == 0 {
+			return
+		}
+		missingKey, missingDst := make([]*datastore.Key, len(missing)), make([]interface{}, len(missing))
+		for i, j := range missing {
+			// the cached entities of the keys that missed may have been partly decoded
+			missingKey[i], missingDst[i] = key[j], proto()
+		}
+		sources := make([]Source, len(missing))
+		err := s.getEntities(c, missingKey, missingDst, sources)
+		me, ok := err.(appengine.MultiError)
+		for i := range missing {
+			r := Result{Key: missingKey[i], Entity: missingDst[i], Source: sources[i]}
+			if ok {
+				r.Err = me[i]
+			} else if err != nil {
+				r.Source, r.Err = Missing, err
+			}
+			results <- r
+		}
+	}()
+	return results, nil
+}
+
+// streamCached sends the entities for key that are cached to results, decoded into dst, and appends the indexes of
+// the others to missing.
+func (s *Cachestore) streamCached(c context.Context, key []*datastore.Key, dst []interface{}, results chan<- Result, missing []int) []int {
+	err := s.GetMultiMemcacheOnly(c, key, dst)
+	me, ok := err.(appengine.MultiError)
+	if err != nil && !ok {
+		for i := range key {
+			missing = append(missing, i)
+		}
+		return missing
+	}
+	hits := 0
+	for i, k := range key {
+		var e error
+		if ok {
+			e = me[i]
+		}
+		if _, decode := e.(*ErrCacheDecode); e == memcache.ErrCacheMiss || decode {
+			missing = append(missing, i)
+			continue
+		}
+		hits++
+		results <- Result{Key: k, Entity: dst[i], Source: FromMemcache, Err: e}
+	}
+	if hits > 0 {
+		count(c, hits, 0, 0)
+	}
+	return missing
+}
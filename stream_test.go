@@ -0,0 +1,73 @@
+package cachestore
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestGetStream(t *testing.T) {
+	requireAetest(t)
+	src := []Struct{{1}, {2}, {3}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Struct", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cache the second
+	err = Get(c, key[1], &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetStream with an absent key
+	absent := datastore.NewKey(c, "Struct", "absentStream", 0, nil)
+	before := Stats()
+	results, err := GetStream(c, append(key, absent), func() interface{} { return &Struct{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Result{
+		{Key: key[1], Entity: &src[1], Source: FromMemcache},
+		{Key: key[0], Entity: &src[0], Source: FromDatastore},
+		{Key: key[2], Entity: &src[2], Source: FromDatastore},
+		{Key: absent, Entity: &Struct{}, Source: Missing, Err: datastore.ErrNoSuchEntity},
+	}
+	i := 0
+	for r := range results {
+		if i >= len(expected) {
+			t.Fatalf("unexpected result %#v", r)
+		}
+		e := expected[i]
+		if !r.Key.Equal(e.Key) || *r.Entity.(*Struct) != *e.Entity.(*Struct) || r.Source != e.Source || r.Err != e.Err {
+			t.Fatalf("expected=%#v actual=%#v", e, r)
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Fatalf("expected=%#v actual=%#v", len(expected), i)
+	}
+	// one call, with the memcache hit and the datastore reads of the misses
+	after := Stats()
+	expectedCounters := Counters{Calls: 1, Keys: 4, Hits: 1, Misses: 3, DatastoreReads: 3}
+	actualCounters := Counters{
+		Calls:          after.Calls - before.Calls,
+		Keys:           after.Keys - before.Keys,
+		Hits:           after.Hits - before.Hits,
+		Misses:         after.Misses - before.Misses,
+		DatastoreReads: after.DatastoreReads - before.DatastoreReads,
+	}
+	if expectedCounters != actualCounters {
+		t.Fatalf("expected=%#v actual=%#v", expectedCounters, actualCounters)
+	}
+	// GetStream into an invalid type
+	_, err = GetStream(c, key, func() interface{} { return Struct{} })
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
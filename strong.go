@@ -0,0 +1,18 @@
+package cachestore
+
+import "context"
+
+type strongKey struct{}
+
+// Strong returns a copy of c for which Get and GetMulti skip memcache and read entities directly from datastore, so
+// they're as consistent as datastore reads. The entities read are still cached, replacing possibly stale cached ones,
+// unless c is also ReadOnly.
+func Strong(c context.Context) context.Context {
+	return context.WithValue(c, strongKey{}, true)
+}
+
+// isStrong returns whether c was returned by Strong.
+func isStrong(c context.Context) bool {
+	strong, _ := c.Value(strongKey{}).(bool)
+	return strong
+}
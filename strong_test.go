@@ -0,0 +1,72 @@
+package cachestore
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestStrong(t *testing.T) {
+	requireAetest(t)
+	src := &Struct{I: 6}
+	// Put
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get to cache
+	err = Get(c, key, &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Put to datastore only, so the cached entity is stale
+	src = &Struct{I: 7}
+	_, err = datastore.Put(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get from datastore without refreshing memcache
+	dst := &Struct{}
+	err = Get(Strong(ReadOnly(c)), key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	dst = &Struct{}
+	err = Get(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (&Struct{I: 6}); !reflect.DeepEqual(expected, dst) {
+		t.Fatalf("expected=%#v actual=%#v", expected, dst)
+	}
+	// Get from datastore, refreshing memcache
+	dst = &Struct{}
+	err = Get(Strong(c), key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	before := Stats()
+	dst = &Struct{}
+	err = Get(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	if hits := Stats().Hits - before.Hits; hits != 1 {
+		t.Fatalf("expected=%#v actual=%#v", 1, hits)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,119 @@
+package cachestore
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+type transactionKey struct{}
+
+// transaction holds the keys written by a transaction, to be removed from memcache once it commits. The keys are
+// held by the Cachestore that wrote them, since Cachestores with different configurations, like KeyPrefix,
+// MemcacheNamespace, KeyFunc, LocalCache or OnEvict, remove entities differently.
+type transaction struct {
+	mu  sync.Mutex
+	key map[*Cachestore][]*datastore.Key
+}
+
+// RunInTransaction runs f in a transaction using the default Cachestore. See Cachestore.RunInTransaction.
+func RunInTransaction(c context.Context, f func(tc context.Context) error, opts *datastore.TransactionOptions) error {
+	return defaultCachestore().RunInTransaction(c, f, opts)
+}
+
+// RunInTransaction runs f in a transaction like datastore.RunInTransaction. Put, PutMulti, Delete and DeleteMulti
+// called with the transaction context given to f don't remove their entities from memcache until the transaction
+// commits, so that concurrent reads can't cache values the transaction is about to replace. Get and GetMulti called
+// with it read datastore directly, without reading or writing memcache, so they're isolated like datastore reads in
+// the transaction. If the transaction commits but removing the entities from memcache fails, the memcache error is
+// returned.
+//
+// Only transaction contexts created by RunInTransaction are recognized: called with a context given by
+// datastore.RunInTransaction, cachestore's functions use memcache as they do outside transactions.
+func (s *Cachestore) RunInTransaction(c context.Context, f func(tc context.Context) error, opts *datastore.TransactionOptions) error {
+	var t *transaction
+	err := datastoreBackend.RunInTransaction(c, func(tc context.Context) error {
+		// a new transaction for every attempt, so that only the keys written by the one that commits are removed
+		t = &transaction{key: map[*Cachestore][]*datastore.Key{}}
+		return f(context.WithValue(tc, transactionKey{}, t))
+	}, opts)
+	if err != nil {
+		return err
+	}
+	for writer, key := range t.key {
+		if errm := writer.uncache(key, c); errm != nil {
+			err = errm
+		}
+	}
+	return err
+}
+
+// transactionFromContext returns the transaction c belongs to, or nil if it's not a RunInTransaction context.
+func transactionFromContext(c context.Context) *transaction {
+	t, _ := c.Value(transactionKey{}).(*transaction)
+	return t
+}
+
+// getFromDatastore gets the entities for key into dst from datastore, for the RunInTransaction or WithBypass context
+// c. Nothing is cached: a transaction may not commit and other transactions may have written since its snapshot.
+func (s *Cachestore) getFromDatastore(c context.Context, key []*datastore.Key, dst interface{}, sources []Source) error {
+	count(c, len(key), len(key), len(key))
+	start := time.Now()
+	err := datastoreBackend.GetMulti(c, key, dst)
+	s.logSlowOp(c, "get", "datastore", len(key), start, err)
+	me, ok := err.(appengine.MultiError)
+	if err != nil && !ok {
+		return err
+	}
+	if me == nil {
+		me = make(appengine.MultiError, len(key))
+	}
+	all := make([]int, len(key))
+	for i := range all {
+		all[i] = i
+	}
+	loadKeys(key, reflect.ValueOf(dst), all, me)
+	if s.IgnoreFieldMismatch {
+		ignoreFieldMismatch(me, all)
+	}
+	if sources != nil {
+		setSources(sources, all, me)
+	}
+	for _, err := range me {
+		if err != nil {
+			return me
+		}
+	}
+	return nil
+}
+
+// evict removes the entities for key from memcache, or defers it until the transaction commits if c is a
+// RunInTransaction context. Entities of UncachedKinds are skipped, and memcache is if c is a WithBypass context.
+func (s *Cachestore) evict(c context.Context, key []*datastore.Key) error {
+	if isBypass(c) {
+		s.removeLocal(c, s.encodeKeys(key))
+		return nil
+	}
+	if len(s.UncachedKinds) > 0 {
+		cached, cachedKey := *new([]int), *new([]*datastore.Key)
+		for i, k := range key {
+			if !s.uncached(k) {
+				cached, cachedKey = append(cached, i), append(cachedKey, k)
+			}
+		}
+		if len(cached) < len(key) {
+			return remapErrors(s.evict(c, cachedKey), cached, len(key))
+		}
+	}
+	if t := transactionFromContext(c); t != nil {
+		t.mu.Lock()
+		t.key[s] = append(t.key[s], key...)
+		t.mu.Unlock()
+		return nil
+	}
+	return s.uncache(key, c)
+}
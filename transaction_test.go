@@ -0,0 +1,277 @@
+package cachestore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+func TestRunInTransactionCommit(t *testing.T) {
+	requireAetest(t)
+	src := Struct{I: 3}
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load memcache with Get
+	dst := *new(Struct)
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Put in a transaction
+	changed := Struct{I: 4}
+	err = RunInTransaction(c, func(tc context.Context) error {
+		if _, err := Put(tc, key, &changed); err != nil {
+			return err
+		}
+		// still cached until commit
+		_, err := memcache.Get(c, defaultCachestore().encodeKey(key))
+		return err
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = memcache.Get(c, defaultCachestore().encodeKey(key))
+	if err != memcache.ErrCacheMiss {
+		t.Fatalf("expected=%#v actual=%#v", memcache.ErrCacheMiss, err)
+	}
+	// Get
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changed, dst) {
+		t.Fatalf("expected=%#v actual=%#v", changed, dst)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunInTransactionRollback(t *testing.T) {
+	requireAetest(t)
+	src := Struct{I: 3}
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load memcache with Get
+	dst := *new(Struct)
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Delete in a transaction that rolls back
+	errRollback := errors.New("rollback")
+	err = RunInTransaction(c, func(tc context.Context) error {
+		if err := Delete(tc, key); err != nil {
+			return err
+		}
+		return errRollback
+	}, nil)
+	if err != errRollback {
+		t.Fatalf("expected=%#v actual=%#v", errRollback, err)
+	}
+	// still cached
+	_, err = memcache.Get(c, defaultCachestore().encodeKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get
+	dst = *new(Struct)
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetInTransactionSkipsMemcache(t *testing.T) {
+	requireAetest(t)
+	src := Struct{I: 3}
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load memcache with Get
+	dst := *new(Struct)
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// update datastore without cachestore
+	changed := Struct{I: 4}
+	_, err = datastore.Put(c, key, &changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Get in a transaction
+	calls := 0
+	err = RunInTransaction(c, func(tc context.Context) error {
+		tc = appengine.WithAPICallFunc(tc, func(ctx context.Context, service, method string, in, out proto.Message) error {
+			if service == "memcache" {
+				calls++
+			}
+			return appengine.APICall(ctx, service, method, in, out)
+		})
+		return Get(tc, key, &dst)
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changed, dst) {
+		t.Fatalf("expected=%#v actual=%#v", changed, dst)
+	}
+	if calls != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, calls)
+	}
+	// Get from memcache, which the transaction didn't cache into
+	err = Get(c, key, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetAllInTransaction(t *testing.T) {
+	requireAetest(t)
+	parent := datastore.NewKey(c, "Parent", "getAllInTransaction", 0, nil)
+	src := Struct{I: 3}
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", parent), &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetAll in a transaction
+	q := datastore.NewQuery("Struct").Ancestor(parent)
+	err = RunInTransaction(c, func(tc context.Context) error {
+		var dst []Struct
+		key, err := GetAll(tc, q, &dst)
+		if err != nil {
+			return err
+		}
+		if len(key) != 1 || !reflect.DeepEqual(src, dst[0]) {
+			t.Fatalf("expected=%#v actual=%#v", []Struct{src}, dst)
+		}
+		// nothing is cached before the transaction commits
+		if _, ok := defaultCachestore().getQueryKeys(c, defaultCachestore().encodeQuery(tc, q)); ok {
+			t.Fatal("expected the query not to be cached")
+		}
+		_, err = memcache.Get(c, defaultCachestore().encodeKey(key[0]))
+		if err != memcache.ErrCacheMiss {
+			t.Fatalf("expected=%#v actual=%#v", memcache.ErrCacheMiss, err)
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExistsMultiInTransaction(t *testing.T) {
+	requireAetest(t)
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), &Struct{I: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load memcache with Get, then delete from datastore without cachestore
+	err = Get(c, key, &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = datastore.Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ExistsMulti in a transaction reads datastore
+	err = RunInTransaction(c, func(tc context.Context) error {
+		exists, err := ExistsMulti(tc, []*datastore.Key{key})
+		if err != nil {
+			return err
+		}
+		if exists[0] {
+			t.Fatal("expected the deleted entity not to exist")
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunInTransactionEvictsWithEachCachestore(t *testing.T) {
+	withFakeBackends(func(m *fakeMemcache, d *fakeDatastore) {
+		c := context.Background()
+		// the same KeyPrefix, but different memcache keys
+		s := []*Cachestore{New(), New()}
+		s[1].KeyFunc = func(k *datastore.Key) string { return "custom:" + k.Encode() }
+		key := make([]*datastore.Key, len(s))
+		for i := range s {
+			var err error
+			key[i], err = s[i].Put(c, datastore.NewIncompleteKey(c, "Struct", nil), &Struct{1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// load memcache with Get
+			err = s[i].Get(c, key[i], &Struct{})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Put with both in a transaction
+		err := s[0].RunInTransaction(c, func(tc context.Context) error {
+			for i := range s {
+				if _, err := s[i].Put(tc, key[i], &Struct{2}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Get what the transaction wrote from both
+		for i := range s {
+			dst := Struct{}
+			err = s[i].Get(c, key[i], &dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dst.I != 2 {
+				t.Fatalf("expected=%#v actual=%#v", 2, dst.I)
+			}
+		}
+	})
+}
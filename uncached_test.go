@@ -0,0 +1,71 @@
+package cachestore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestUncachedKinds(t *testing.T) {
+	requireAetest(t)
+	UncachedKinds = map[string]bool{"Huge": true}
+	defer func() { UncachedKinds = nil }()
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil), datastore.NewIncompleteKey(c, "Huge", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti from datastore, then only the cached kind from memcache
+	for i := 0; i < 2; i++ {
+		dst := make([]Struct, len(key))
+		sources, err := GetMultiWithSource(c, key, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src, dst) {
+			t.Fatalf("expected=%#v actual=%#v", src, dst)
+		}
+		expected := []Source{FromDatastore, FromDatastore}
+		if i == 1 {
+			expected[0] = FromMemcache
+		}
+		if !reflect.DeepEqual(expected, sources) {
+			t.Fatalf("expected=%v actual=%v", expected, sources)
+		}
+	}
+	// Put, Get and Delete the uncached kind without memcache
+	noMemcache := appengine.WithAPICallFunc(c, func(ctx context.Context, s, m string, in, out proto.Message) error {
+		if s == "memcache" {
+			t.Fatalf("unexpected memcache call %s", m)
+		}
+		return appengine.APICall(ctx, s, m, in, out)
+	})
+	_, err = Put(noMemcache, key[1], &src[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Get(noMemcache, key[1], &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Delete(noMemcache, key[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SetCacheOnly can't cache the uncached kind
+	err = SetCacheOnly(noMemcache, key[1], &src[1], 0)
+	if err != errNotAdded {
+		t.Fatalf("expected=%#v actual=%#v", errNotAdded, err)
+	}
+	// Delete
+	err = Delete(c, key[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+}
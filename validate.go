@@ -0,0 +1,118 @@
+package cachestore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+var (
+	typeOfBlobKey   = reflect.TypeOf(appengine.BlobKey(""))
+	typeOfByteSlice = reflect.TypeOf([]byte(nil))
+	typeOfGeoPoint  = reflect.TypeOf(appengine.GeoPoint{})
+	typeOfKey       = reflect.TypeOf(&datastore.Key{})
+	typeOfTime      = reflect.TypeOf(time.Time{})
+)
+
+// Validate returns warnings about the fields of src, a struct or struct pointer, that datastore won't save: unexported
+// fields and fields of unsupported types like maps, channels and funcs. Those fields are silently lost when entities
+// are cached or written to datastore, so call Validate in tests to catch them.
+func Validate(src interface{}) []string {
+	t := reflect.TypeOf(src)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return []string{fmt.Sprintf("%v is not a struct or struct pointer", t)}
+	}
+	return validateStruct(t, t.Name(), map[reflect.Type]bool{})
+}
+
+// ValidatePut checks whether the entities of src could be cached for key using the default Cachestore. See
+// Cachestore.ValidatePut.
+func ValidatePut(c context.Context, key []*datastore.Key, src interface{}) error {
+	return defaultCachestore().ValidatePut(c, key, src)
+}
+
+// ValidatePut checks whether the entities of src could be cached for key, without writing them to datastore or
+// memcache, for example before an import. It encodes each entity like Put with WriteThrough, reporting the entities
+// that can't be, like ones with property values of types that aren't registered with gob, and the keys too long for
+// memcache, in an appengine.MultiError. Incomplete keys are checked too: the keys datastore allocates for them are
+// even longer. Entities larger than memcache's item limit are valid, since they're split across several items. src
+// must satisfy the same conditions as PutMulti's src.
+func (s *Cachestore) ValidatePut(c context.Context, key []*datastore.Key, src interface{}) error {
+	if err := checkMultiLen(key, src); err != nil {
+		return err
+	}
+	v := reflect.ValueOf(src)
+	multiArgType, _ := checkMultiArg(v)
+	var multiErr appengine.MultiError
+	for i, k := range key {
+		if s.uncached(k) {
+			continue
+		}
+		_, err := s.encode(elem(v, i, multiArgType).Interface())
+		if encodedKey := s.encodeKey(k); err == nil && len(encodedKey) > maxKeySize {
+			err = fmt.Errorf("cachestore: memcache key of %v is %d bytes, longer than memcache's limit of %d (set KeyFunc to shorten it)", k, len(encodedKey), maxKeySize)
+		}
+		if err != nil {
+			if multiErr == nil {
+				multiErr = make(appengine.MultiError, len(key))
+			}
+			multiErr[i] = err
+		}
+	}
+	if multiErr != nil {
+		return multiErr
+	}
+	return nil
+}
+
+// validateStruct returns the warnings about the fields of the struct type t, prefixing their names with path.
+func validateStruct(t reflect.Type, path string, seen map[reflect.Type]bool) []string {
+	if seen[t] {
+		return []string{fmt.Sprintf("%s: recursive struct %v is not supported", path, t)}
+	}
+	seen[t] = true
+	defer delete(seen, t)
+	warnings := *new([]string)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("datastore"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		fieldPath := path + "." + f.Name
+		if f.PkgPath != "" && !f.Anonymous {
+			warnings = append(warnings, fmt.Sprintf("%s: unexported field is not saved", fieldPath))
+			continue
+		}
+		warnings = append(warnings, validateType(f.Type, fieldPath, seen)...)
+	}
+	return warnings
+}
+
+// validateType returns the warnings about a field of type t named path.
+func validateType(t reflect.Type, path string, seen map[reflect.Type]bool) []string {
+	switch t {
+	case typeOfBlobKey, typeOfByteSlice, typeOfGeoPoint, typeOfKey, typeOfTime:
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.String,
+		reflect.Float32, reflect.Float64:
+		return nil
+	case reflect.Struct:
+		return validateStruct(t, path, seen)
+	case reflect.Slice:
+		if t.Elem().Kind() != reflect.Slice || t.Elem() == typeOfByteSlice {
+			return validateType(t.Elem(), path, seen)
+		}
+	}
+	return []string{fmt.Sprintf("%s: %v fields are not supported", path, t)}
+}
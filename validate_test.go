@@ -0,0 +1,91 @@
+package cachestore
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+type Invalid struct {
+	I       int
+	T       time.Time
+	K       *datastore.Key
+	B       []byte
+	S       []string
+	private string
+	F       func()
+	M       map[string]int
+	Nested  struct {
+		C chan int
+	}
+	Skipped func() `datastore:"-"`
+}
+
+func TestValidate(t *testing.T) {
+	expected := []string{
+		"Invalid.private: unexported field is not saved",
+		"Invalid.F: func() fields are not supported",
+		"Invalid.M: map[string]int fields are not supported",
+		"Invalid.Nested.C: chan int fields are not supported",
+	}
+	actual := Validate(&Invalid{})
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected=%#v actual=%#v", expected, actual)
+	}
+	if actual := Validate(Struct{}); len(actual) != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, actual)
+	}
+}
+
+// UnregisteredValue saves a property value of a type that isn't registered with gob.
+type UnregisteredValue struct{}
+
+func (UnregisteredValue) Load([]datastore.Property) error {
+	return nil
+}
+
+func (UnregisteredValue) Save() ([]datastore.Property, error) {
+	return []datastore.Property{{Name: "U", Value: unregistered{"u"}}}, nil
+}
+
+func TestValidatePut(t *testing.T) {
+	requireAetest(t)
+	var long *datastore.Key
+	for i := 0; i < 5; i++ {
+		long = datastore.NewKey(c, "Struct", strings.Repeat("ancestor", 10), 0, long)
+	}
+	key := []*datastore.Key{
+		datastore.NewKey(c, "Struct", "validatePut", 0, nil),
+		datastore.NewKey(c, "UnregisteredValue", "validatePut", 0, nil),
+		datastore.NewKey(c, "LargeStruct", "validatePut", 0, nil),
+		long,
+		datastore.NewIncompleteKey(c, "Struct", long),
+	}
+	src := []interface{}{&Struct{1}, &UnregisteredValue{}, &LargeStruct{make([]byte, 3*maxItemSize)}, &Struct{2}, &Struct{3}}
+	// ValidatePut
+	err := ValidatePut(c, key, src)
+	me, ok := err.(appengine.MultiError)
+	if !ok {
+		t.Fatalf("expected=%#v actual=%#v", appengine.MultiError{}, err)
+	}
+	for i, s := range []string{"", "gob.Register", "", "KeyFunc", "KeyFunc"} {
+		if s == "" && me[i] != nil || s != "" && (me[i] == nil || !strings.Contains(me[i].Error(), s)) {
+			t.Fatalf("%d: expected=%#v actual=%#v", i, s, me[i])
+		}
+	}
+	// nothing was written
+	err = GetMulti(c, key[:4], make([]datastore.PropertyList, 4))
+	me, ok = err.(appengine.MultiError)
+	if !ok {
+		t.Fatalf("expected=%#v actual=%#v", appengine.MultiError{}, err)
+	}
+	for i, err := range me {
+		if err != datastore.ErrNoSuchEntity {
+			t.Fatalf("%d: expected=%#v actual=%#v", i, datastore.ErrNoSuchEntity, err)
+		}
+	}
+}
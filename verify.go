@@ -0,0 +1,112 @@
+package cachestore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// ErrCacheDivergence is returned by Verify when the entity cached for Key differs from the one stored in datastore,
+// which means a write wasn't evicted from memcache.
+type ErrCacheDivergence struct {
+	Key      *datastore.Key
+	Property string        // the first differing property in name order, empty if the entity isn't in datastore
+	Cached   []interface{} // the values of Property in the cached entity
+	Stored   []interface{} // the values of Property in datastore's entity
+}
+
+func (e *ErrCacheDivergence) Error() string {
+	if e.Property == "" {
+		return fmt.Sprintf("cachestore: entity %v is cached but not stored in datastore", e.Key)
+	}
+	return fmt.Sprintf("cachestore: cached entity %v differs from datastore's in property %q: cached %v, stored %v", e.Key, e.Property, e.Cached, e.Stored)
+}
+
+// Verify loads the entity stored for key into dst and checks it against the cached one using the default Cachestore.
+// See Cachestore.Verify.
+func Verify(c context.Context, key *datastore.Key, dst interface{}) error {
+	return defaultCachestore().Verify(c, key, dst)
+}
+
+// Verify loads the entity stored in datastore for key into dst, and returns an *ErrCacheDivergence if the entity
+// cached in memcache for key differs from it, to catch invalidation bugs, for example in staging. Both entities are
+// decoded with the Cachestore's Codec and compared property by property, whatever the order of their properties. An
+// entity that isn't cached doesn't diverge, nor does one that's cached with another Version or before Flush, since
+// reads ignore them. Verify neither caches nor evicts anything: the diverging entity is still cached afterwards.
+func (s *Cachestore) Verify(c context.Context, key *datastore.Key, dst interface{}) error {
+	if !isEntity(reflect.ValueOf(dst)) {
+		return datastore.ErrInvalidEntityType
+	}
+	encodedKey := s.encodeKey(key)
+	items, _, err := s.getItems(c, []string{encodedKey})
+	if err != nil {
+		return err
+	}
+	var cached []datastore.Property
+	if item := items[encodedKey]; item != nil {
+		if cached, err = s.codec().Unmarshal(item.Value); err != nil {
+			return &ErrCacheDecode{Key: key, Err: err}
+		}
+	}
+	stored := make([]datastore.PropertyList, 1)
+	err = datastoreBackend.GetMulti(c, []*datastore.Key{key}, stored)
+	if me, ok := err.(appengine.MultiError); ok {
+		err = me[0]
+	}
+	if err == datastore.ErrNoSuchEntity && cached != nil {
+		return &ErrCacheDivergence{Key: key}
+	} else if err != nil {
+		return err
+	}
+	// encode and decode datastore's entity like cached ones, so the two are decoded with the same codec
+	value, err := s.encode(&stored[0])
+	if err != nil {
+		return err
+	}
+	if err := s.decode(dst, value); err != nil {
+		return err
+	}
+	if cached == nil {
+		return nil
+	}
+	decoded, err := s.codec().Unmarshal(value)
+	if err != nil {
+		return err
+	}
+	return diffProperties(key, cached, decoded)
+}
+
+// diffProperties returns an *ErrCacheDivergence for the first property, in name order, whose values differ between
+// cached and stored, or nil if none do. The values of multiple-valued properties are compared in order.
+func diffProperties(key *datastore.Key, cached, stored []datastore.Property) error {
+	cachedValues, storedValues := propertyValues(cached), propertyValues(stored)
+	names := *new([]string)
+	for name := range cachedValues {
+		names = append(names, name)
+	}
+	for name := range storedValues {
+		if _, ok := cachedValues[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !reflect.DeepEqual(cachedValues[name], storedValues[name]) {
+			return &ErrCacheDivergence{Key: key, Property: name, Cached: cachedValues[name], Stored: storedValues[name]}
+		}
+	}
+	return nil
+}
+
+// propertyValues returns the values of properties by name.
+func propertyValues(properties []datastore.Property) map[string][]interface{} {
+	values := make(map[string][]interface{}, len(properties))
+	for _, p := range properties {
+		values[p.Name] = append(values[p.Name], p.Value)
+	}
+	return values
+}
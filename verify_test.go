@@ -0,0 +1,83 @@
+package cachestore
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestVerify(t *testing.T) {
+	requireAetest(t)
+	src := &Struct{I: 3}
+	// Put
+	key, err := Put(c, datastore.NewIncompleteKey(c, "Struct", nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Verify before caching
+	dst := &Struct{}
+	err = Verify(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// Verify the cached entity
+	err = Get(c, key, &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Verify(c, key, &Struct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Verify after updating datastore without cachestore
+	updated := &Struct{I: 4}
+	_, err = datastore.Put(c, key, updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst = &Struct{}
+	err = Verify(c, key, dst)
+	expected := &ErrCacheDivergence{Key: key, Property: "I", Cached: []interface{}{int64(3)}, Stored: []interface{}{int64(4)}}
+	if !reflect.DeepEqual(expected, err) {
+		t.Fatalf("expected=%#v actual=%#v", expected, err)
+	}
+	if !reflect.DeepEqual(updated, dst) {
+		t.Fatalf("expected=%#v actual=%#v", updated, dst)
+	}
+	// Verify after deleting from datastore without cachestore
+	err = datastore.Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Verify(c, key, &Struct{})
+	if e, ok := err.(*ErrCacheDivergence); !ok || e.Property != "" {
+		t.Fatalf("expected=%#v actual=%#v", &ErrCacheDivergence{Key: key}, err)
+	}
+	// Delete
+	err = Delete(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Verify(c, key, &Struct{})
+	if err != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+}
+
+func TestDiffPropertiesIgnoresOrder(t *testing.T) {
+	key := datastore.NewKey(c, "Struct", "diff", 0, nil)
+	cached := []datastore.Property{{Name: "A", Value: int64(1)}, {Name: "B", Value: "b"}, {Name: "C", Value: int64(2), Multiple: true}, {Name: "C", Value: int64(3), Multiple: true}}
+	stored := []datastore.Property{{Name: "C", Value: int64(2), Multiple: true}, {Name: "B", Value: "b"}, {Name: "C", Value: int64(3), Multiple: true}, {Name: "A", Value: int64(1)}}
+	if err := diffProperties(key, cached, stored); err != nil {
+		t.Fatal(err)
+	}
+	stored = stored[1:]
+	err := diffProperties(key, cached, stored)
+	if e, ok := err.(*ErrCacheDivergence); !ok || e.Property != "C" {
+		t.Fatalf("expected a divergence of C, actual=%#v", err)
+	}
+}
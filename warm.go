@@ -0,0 +1,60 @@
+package cachestore
+
+import (
+	"context"
+	"reflect"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// CacheEntity caches src for key using the default Cachestore. See Cachestore.CacheEntity.
+func CacheEntity(c context.Context, key *datastore.Key, src interface{}) error {
+	return defaultCachestore().CacheEntity(c, key, src)
+}
+
+// CacheMulti is a batch version of CacheEntity. See Cachestore.CacheMulti.
+func CacheMulti(c context.Context, key []*datastore.Key, src interface{}) error {
+	return defaultCachestore().CacheMulti(c, key, src)
+}
+
+// Warm loads the entities for key into dst from datastore and caches them using the default Cachestore. See
+// Cachestore.Warm.
+func Warm(c context.Context, key []*datastore.Key, dst interface{}) error {
+	return defaultCachestore().Warm(c, key, dst)
+}
+
+// CacheEntity caches src, an entity read from datastore for key without cachestore, like an entity returned by a
+// query's Iterator, so that reading it by key hits memcache. src must satisfy the same conditions as Put's src. src
+// replaces any entity cached for key, so it mustn't be older than the entity in datastore.
+func (s *Cachestore) CacheEntity(c context.Context, key *datastore.Key, src interface{}) error {
+	err := s.CacheMulti(c, []*datastore.Key{key}, []interface{}{src})
+	if me, ok := err.(appengine.MultiError); ok {
+		return me[0]
+	}
+	return err
+}
+
+// CacheMulti is a batch version of CacheEntity. Incomplete keys are skipped.
+func (s *Cachestore) CacheMulti(c context.Context, key []*datastore.Key, src interface{}) error {
+	if err := checkMultiLen(key, src); err != nil {
+		return err
+	}
+	s.removeLocal(c, s.encodeKeys(key))
+	return s.cache(key, src, nil, c)
+}
+
+// Warm loads the entities for key into dst from datastore and caches them, replacing whatever is cached for them, like
+// RefreshMulti for any number of keys. Use it to populate memcache with entities that are about to be read heavily,
+// for example after a deploy changed Version. The keys are read in batches as large as datastore allows, and the
+// errors of keys without entities are merged into one appengine.MultiError. A batch failing with another error stops
+// Warm, leaving the later batches uncached.
+func (s *Cachestore) Warm(c context.Context, key []*datastore.Key, dst interface{}) error {
+	if err := checkMultiLen(key, dst); err != nil {
+		return err
+	}
+	v := reflect.ValueOf(dst)
+	return batch(len(key), maxGetBatchSize, func(i, j int) error {
+		return s.RefreshMulti(c, key[i:j], v.Slice(i, j).Interface())
+	})
+}
@@ -0,0 +1,112 @@
+package cachestore
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestCacheMulti(t *testing.T) {
+	requireAetest(t)
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{datastore.NewIncompleteKey(c, "Warm", nil), datastore.NewIncompleteKey(c, "Warm", nil)}
+	// PutMulti
+	key, err := PutMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// iterate over a query and cache what it returns
+	key, entities := *new([]*datastore.Key), *new([]Struct)
+	for it := datastore.NewQuery("Warm").Run(c); ; {
+		var e Struct
+		k, err := it.Next(&e)
+		if err == datastore.Done {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		key, entities = append(key, k), append(entities, e)
+	}
+	err = CacheMulti(c, append(key, datastore.NewIncompleteKey(c, "Warm", nil)), append(entities, Struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetMulti from memcache
+	before := Stats()
+	dst := make([]Struct, len(key))
+	err = GetMulti(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entities, dst) {
+		t.Fatalf("expected=%#v actual=%#v", entities, dst)
+	}
+	if hits := Stats().Hits - before.Hits; hits != uint64(len(key)) {
+		t.Fatalf("expected=%#v actual=%#v", len(key), hits)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	requireAetest(t)
+	n := maxGetBatchSize + 200
+	key, src := make([]*datastore.Key, n), make([]Struct, n)
+	for i := range key {
+		key[i], src[i] = datastore.NewKey(c, "Warm", "warm"+strconv.Itoa(i), 0, nil), Struct{i}
+	}
+	// put to datastore without caching
+	_, err := putMulti(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Warm, with a key without an entity
+	key = append(key, datastore.NewKey(c, "Warm", "missing", 0, nil))
+	dst := make([]Struct, len(key))
+	err = Warm(c, key, dst)
+	me, ok := err.(appengine.MultiError)
+	if !ok || len(me) != len(key) || me[n] != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+	for i := range src {
+		if me[i] != nil || !reflect.DeepEqual(src[i], dst[i]) {
+			t.Fatalf("expected=%#v actual=%#v", src[i], dst[i])
+		}
+	}
+	// GetMulti from memcache
+	before := Stats()
+	for i := 0; i < n; i += 600 {
+		j := i + 600
+		if j > n {
+			j = n
+		}
+		dst := make([]Struct, j-i)
+		err = GetMulti(c, key[i:j], dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src[i:j], dst) {
+			t.Fatalf("expected=%#v actual=%#v", src[i:j], dst)
+		}
+	}
+	if reads := Stats().DatastoreReads - before.DatastoreReads; reads != 0 {
+		t.Fatalf("expected=%#v actual=%#v", 0, reads)
+	}
+	// DeleteMulti
+	for i := 0; i < n; i += 600 {
+		j := i + 600
+		if j > n {
+			j = n
+		}
+		err = DeleteMulti(c, key[i:j])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
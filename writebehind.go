@@ -0,0 +1,101 @@
+package cachestore
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"reflect"
+
+	"google.golang.org/appengine/datastore"
+)
+
+var (
+	errNoWriteBehindQueue       = errors.New("cachestore: PutMultiBehind needs a WriteBehindQueue")
+	errWriteBehindInTransaction = errors.New("cachestore: PutMultiBehind can't write in a transaction")
+)
+
+// writeBehindTask is the payload of the tasks PutMultiBehind enqueues.
+type writeBehindTask struct {
+	Keys   []string // encoded datastore keys
+	Values [][]byte // entities encoded by the Cachestore's Codec
+}
+
+// PutMultiBehind caches the entities of src for key now and writes them to datastore later using the default
+// Cachestore. See Cachestore.PutMultiBehind.
+func PutMultiBehind(c context.Context, key []*datastore.Key, src interface{}) error {
+	return defaultCachestore().PutMultiBehind(c, key, src)
+}
+
+// RunWriteBehind writes the entities of a task enqueued by PutMultiBehind to datastore using the default Cachestore.
+// See Cachestore.RunWriteBehind.
+func RunWriteBehind(c context.Context, task []byte) error {
+	return defaultCachestore().RunWriteBehind(c, task)
+}
+
+// PutMultiBehind caches the entities of src for key in memcache, and enqueues a task with WriteBehindQueue that
+// writes them to datastore later, by calling RunWriteBehind. It returns once the task is enqueued, without waiting
+// for datastore, for writes that can tolerate losing durability for a while. Reads see the entities as soon as they're
+// cached, unless memcache evicts them before the task runs, while Strong reads and queries see what datastore had.
+// Tasks may run in any order, so if the same entity is written again before its task runs, either write may be the
+// one that lasts. The entities must fit in a task, so keep batches small.
+//
+// key must be complete, since datastore allocates IDs when it writes. If enqueuing the task fails nothing is
+// written, if caching fails the entities are still written to datastore and the memcache error is returned. src
+// must satisfy the same conditions as PutMulti's src.
+func (s *Cachestore) PutMultiBehind(c context.Context, key []*datastore.Key, src interface{}) error {
+	if s.WriteBehindQueue == nil {
+		return errNoWriteBehindQueue
+	}
+	if transactionFromContext(c) != nil {
+		return errWriteBehindInTransaction
+	}
+	if err := checkMultiLen(key, src); err != nil {
+		return err
+	}
+	v := reflect.ValueOf(src)
+	multiArgType, _ := checkMultiArg(v)
+	task := writeBehindTask{Keys: make([]string, len(key)), Values: make([][]byte, len(key))}
+	for i, k := range key {
+		if k.Incomplete() {
+			return errIncompleteKey
+		}
+		value, err := s.encode(elem(v, i, multiArgType).Interface())
+		if err != nil {
+			return err
+		}
+		task.Keys[i], task.Values[i] = k.Encode(), value
+	}
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(task); err != nil {
+		return err
+	}
+	if err := s.WriteBehindQueue(c, b.Bytes()); err != nil {
+		return err
+	}
+	return s.CacheMulti(c, key, src)
+}
+
+// RunWriteBehind writes the entities of task, enqueued by PutMultiBehind, to datastore with PutMulti, which removes
+// them from memcache or caches them again with WriteThrough. Call it from the handler of WriteBehindQueue's tasks,
+// with the same configuration as the Cachestore that enqueued them, and retry the task if it fails.
+func (s *Cachestore) RunWriteBehind(c context.Context, task []byte) error {
+	var t writeBehindTask
+	if err := gob.NewDecoder(bytes.NewReader(task)).Decode(&t); err != nil {
+		return err
+	}
+	key, src := make([]*datastore.Key, len(t.Keys)), make([]datastore.PropertyList, len(t.Keys))
+	for i := range t.Keys {
+		k, err := datastore.DecodeKey(t.Keys[i])
+		if err != nil {
+			return err
+		}
+		properties, err := s.codec().Unmarshal(t.Values[i])
+		if err != nil {
+			return err
+		}
+		key[i], src[i] = k, properties
+	}
+	_, err := s.PutMulti(c, key, src)
+	return err
+}
@@ -0,0 +1,73 @@
+package cachestore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestPutMultiBehind(t *testing.T) {
+	requireAetest(t)
+	var tasks [][]byte
+	WriteBehindQueue = func(c context.Context, task []byte) error {
+		tasks = append(tasks, task)
+		return nil
+	}
+	defer func() { WriteBehindQueue = nil }()
+	src := []Struct{{1}, {2}}
+	key := []*datastore.Key{datastore.NewKey(c, "Struct", "behind1", 0, nil), datastore.NewKey(c, "Struct", "behind2", 0, nil)}
+	// PutMultiBehind caches now
+	err := PutMultiBehind(c, key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := make([]Struct, len(key))
+	err = GetMultiMemcacheOnly(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	err = datastore.Get(c, key[0], &Struct{})
+	if err != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected=%#v actual=%#v", datastore.ErrNoSuchEntity, err)
+	}
+	// the task writes to datastore later
+	if len(tasks) != 1 {
+		t.Fatalf("expected=%#v actual=%#v", 1, len(tasks))
+	}
+	err = RunWriteBehind(c, tasks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst = make([]Struct, len(key))
+	err = datastore.GetMulti(c, key, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("expected=%#v actual=%#v", src, dst)
+	}
+	// without a queue, or with an incomplete key, nothing is written
+	WriteBehindQueue = nil
+	err = PutMultiBehind(c, key, src)
+	if err != errNoWriteBehindQueue {
+		t.Fatalf("expected=%#v actual=%#v", errNoWriteBehindQueue, err)
+	}
+	WriteBehindQueue = func(c context.Context, task []byte) error {
+		t.Fatal("unexpected task")
+		return nil
+	}
+	err = PutMultiBehind(c, []*datastore.Key{datastore.NewIncompleteKey(c, "Struct", nil)}, src[:1])
+	if err != errIncompleteKey {
+		t.Fatalf("expected=%#v actual=%#v", errIncompleteKey, err)
+	}
+	// DeleteMulti
+	err = DeleteMulti(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}